@@ -0,0 +1,42 @@
+package redirect
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// targetAllowed checks target against allowed hosts and schemes of engine. All targets are allowed if lists are not
+// set. With hosts list, targets with scheme but without host (ex: javascript:, https:/example.com) are rejected and
+// relative targets are allowed only as absolute paths on the same server (not //host or /\host).
+func (eng *engine) targetAllowed(target string) bool {
+	if eng.allowHosts == nil && len(eng.allowSchemes) == 0 {
+		return true
+	}
+	if eng.allowHosts != nil {
+		target = strings.ReplaceAll(target, `\`, "/") // browsers treat backslash as slash
+	}
+	u, err := url.Parse(asciiHost(target))
+	if err != nil {
+		return false
+	}
+	if u.Scheme != "" && len(eng.allowSchemes) > 0 && !slices.Contains(eng.allowSchemes, strings.ToLower(u.Scheme)) {
+		return false
+	}
+	if eng.allowHosts == nil {
+		return true
+	}
+	if u.Host != "" {
+		return eng.allowHosts.match(strings.ToLower(u.Hostname()))
+	}
+	if u.Scheme != "" {
+		return false // host can't be checked by host list
+	}
+	return sameServerPath(u.Path)
+}
+
+// sameServerPath checks that decoded relative path starts with single slash, so browser keeps host of redirect server.
+func sameServerPath(path string) bool {
+	path = strings.ReplaceAll(path, `\`, "/")
+	return path == "/" || (len(path) > 1 && path[0] == '/' && path[1] != '/')
+}
@@ -0,0 +1,68 @@
+package redirect
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestTargetAllowed(t *testing.T) {
+	eng := NewTestEngine(nil, WithAllowedTargets("example.com", "*.example.org")).(*engine)
+	defer eng.Close()
+
+	cases := []struct {
+		target  string
+		allowed bool
+	}{
+		{"https://example.com/promo", true},
+		{"https://www.example.org/", true},
+		{"/promo", true},
+		{"/", true},
+		{"https://evil.com/", false},
+		{"//evil.com", false},
+		{"/\\evil.com", false},
+		{"/%5Cevil.com", false},
+		{"\\\\evil.com", false},
+		{"https:/evil.com", false},
+		{"javascript:alert(1)", false},
+		{"mailto:admin@example.com", false},
+		{"promo", false},
+	}
+	for _, c := range cases {
+		if got := eng.targetAllowed(c.target); got != c.allowed {
+			t.Errorf("targetAllowed(%q) = %v, want %v", c.target, got, c.allowed)
+		}
+	}
+}
+
+func TestTargetAllowedSchemes(t *testing.T) {
+	eng := NewTestEngine(nil, WithAllowedSchemes("https")).(*engine)
+	defer eng.Close()
+
+	for target, allowed := range map[string]bool{
+		"https://evil.com/":   true,
+		"http://example.com/": false,
+		"javascript:alert(1)": false,
+		"/promo":              true,
+	} {
+		if got := eng.targetAllowed(target); got != allowed {
+			t.Errorf("targetAllowed(%q) = %v, want %v", target, got, allowed)
+		}
+	}
+}
+
+func TestServeHTTPBlockedTarget(t *testing.T) {
+	eng := NewTestEngine(map[string]string{"go": "{{.Query.to}}"}, WithAllowedTargets("example.com"))
+	defer eng.Close()
+
+	for _, to := range []string{"//evil.com", "/%5Cevil.com", "https:/evil.com", "javascript:alert(1)"} {
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/go", nil)
+		rq.URL.RawQuery = "to=" + url.QueryEscape(to)
+		eng.ServeHTTP(rec, rq)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("target %q: status %d, want %d (location %q)", to, rec.Code, http.StatusForbidden, rec.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package redirect
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	rulesPath        = "_rules" // prefix of REST API of rules
+	statsPath        = "_stats" // prefix of stats reset API
+	queryOffset      = "offset"
+	queryLimit       = "limit"
+	querySearch      = "q"
+	queryTag         = "tag"
+	querySort        = "sort"
+	sortHits         = "hits" // value of querySort: most visited first
+	headerTotalCount = "X-Total-Count"
+)
+
+// REST API of rules: _rules/ (GET list, POST create), _rules/{url} (GET, PUT, DELETE) and _rules/{url}/qr.png (GET).
+func (ui *basicUI) rules(wr http.ResponseWriter, rq *http.Request) {
+	path := strings.TrimPrefix(strings.TrimPrefix(rq.URL.Path, "/"), rulesPath)
+	service := strings.TrimPrefix(path, "/")
+	if service == "" {
+		switch rq.Method {
+		case http.MethodGet:
+			ui.listRules(wr, rq)
+		case http.MethodPost:
+			ui.createRule(wr, rq)
+		default:
+			wr.Header().Set("Allow", "GET, POST")
+			http.Error(wr, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if name := strings.TrimSuffix(service, qrSuffix); name != service && rq.Method == http.MethodGet {
+		if _, exists, err := ui.storage.Get(rq.Context(), name); err == nil && exists {
+			ui.qr(name, wr, rq)
+			return
+		}
+	}
+	switch rq.Method {
+	case http.MethodGet:
+		ui.getRule(service, wr, rq)
+	case http.MethodPut:
+		ui.updateRule(service, wr, rq)
+	case http.MethodDelete:
+		ui.deleteRule(service, wr, rq)
+	default:
+		wr.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(wr, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// RuleHits is rule with current stats, item of rules list.
+type RuleHits struct {
+	Rule
+	Hits int64 `json:"hits"`
+	Bots int64 `json:"bots"` // visits of robots included in hits
+}
+
+// list of rules with hits, see queryRules for filter and order.
+func (ui *basicUI) listRules(wr http.ResponseWriter, rq *http.Request) {
+	rules, ok := ui.queryRules(wr, rq)
+	if !ok {
+		return
+	}
+	sendJSON(rules, wr)
+}
+
+// rules with hits filtered by query params: q (substring of URL, location or description, case-insensitive), tag
+// (rules with the tag), offset and limit.
+// Rules are in order of SortRules or most visited first if sort=hits. Total number of matched rules is set to header
+// X-Total-Count. Sends error and returns false if failed.
+func (ui *basicUI) queryRules(wr http.ResponseWriter, rq *http.Request) ([]*RuleHits, bool) {
+	query := rq.URL.Query()
+	offset, limit := 0, 0
+	for name, value := range map[string]*int{queryOffset: &offset, queryLimit: &limit} {
+		if text := query.Get(name); text != "" {
+			v, err := strconv.Atoi(text)
+			if err != nil || v < 0 {
+				http.Error(wr, name+" should be non-negative integer", http.StatusBadRequest)
+				return nil, false
+			}
+			*value = v
+		}
+	}
+	order := query.Get(querySort)
+	if order != "" && order != sortHits {
+		http.Error(wr, querySort+" should be empty or "+sortHits, http.StatusBadRequest)
+		return nil, false
+	}
+	rules, err := ui.storage.All(rq.Context())
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if search := strings.ToLower(query.Get(querySearch)); search != "" {
+		var found = make([]*Rule, 0, len(rules))
+		for _, rule := range rules {
+			if strings.Contains(strings.ToLower(rule.URL), search) || strings.Contains(strings.ToLower(rule.LocationTemplate), search) ||
+				strings.Contains(strings.ToLower(rule.Description), search) {
+				found = append(found, rule)
+			}
+		}
+		rules = found
+	}
+	if tag := query.Get(queryTag); tag != "" {
+		var found = make([]*Rule, 0, len(rules))
+		for _, rule := range rules {
+			if slices.Contains(rule.Tags, tag) {
+				found = append(found, rule)
+			}
+		}
+		rules = found
+	}
+	SortRules(rules)
+	var entries = make([]*RuleHits, 0, len(rules))
+	for _, rule := range rules {
+		hits, bots, err := ui.counts(rule.URL)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+		entries = append(entries, &RuleHits{Rule: *rule, Hits: hits, Bots: bots})
+	}
+	if order == sortHits {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Hits > entries[j].Hits
+		})
+	}
+	wr.Header().Set(headerTotalCount, strconv.Itoa(len(entries)))
+	if offset > len(entries) {
+		offset = len(entries)
+	}
+	entries = entries[offset:]
+	if limit > 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+	return entries, true
+}
+
+func (ui *basicUI) getRule(service string, wr http.ResponseWriter, rq *http.Request) {
+	rule, exists, err := ui.storage.Get(rq.Context(), service)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.NotFound(wr, rq)
+		return
+	}
+	sendJSON(&rule, wr)
+}
+
+// create rule from JSON body. Responds 201 Created with rule location or 409 Conflict if rule already exists.
+func (ui *basicUI) createRule(wr http.ResponseWriter, rq *http.Request) {
+	var rule Rule
+	if err := json.NewDecoder(rq.Body).Decode(&rule); err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rb, err := snapshotRules(rq.Context(), ui.storage, rule.URL)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rb.rules[rule.URL] != nil {
+		http.Error(wr, ErrRuleExists.Error(), http.StatusConflict)
+		return
+	}
+	err = ui.apply(rq, rb, func() error {
+		return CreateRule(rq.Context(), ui.storage, rule)
+	})
+	if err != nil {
+		sendMutationError(wr, err)
+		return
+	}
+	wr.Header().Set("Location", rulesPath+"/"+(&url.URL{Path: rule.URL}).EscapedPath())
+	wr.WriteHeader(http.StatusCreated)
+}
+
+// update (or create) rule from JSON body. URL in body, if set, should match URL in path.
+func (ui *basicUI) updateRule(service string, wr http.ResponseWriter, rq *http.Request) {
+	var rule Rule
+	if err := json.NewDecoder(rq.Body).Decode(&rule); err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rule.URL != "" && rule.URL != service {
+		http.Error(wr, "rule URL doesn't match URL in path", http.StatusBadRequest)
+		return
+	}
+	rule.URL = service
+	rb, err := snapshotRules(rq.Context(), ui.storage, service)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ui.saveRule(rq, rb, rule, wr) {
+		return
+	}
+	if rb.rules[service] != nil {
+		wr.WriteHeader(http.StatusNoContent)
+	} else {
+		wr.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (ui *basicUI) deleteRule(service string, wr http.ResponseWriter, rq *http.Request) {
+	rule, exists, err := ui.storage.Get(rq.Context(), service)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.NotFound(wr, rq)
+		return
+	}
+	rb := &rollback{rules: map[string]*Rule{service: &rule}}
+	err = ui.apply(rq, rb, func() error {
+		return ui.storage.Delete(rq.Context(), service)
+	})
+	if err != nil {
+		sendMutationError(wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+// put rule and reload engine (rule is restored from snapshot if reload failed). Sends error and returns false if
+// failed.
+func (ui *basicUI) saveRule(rq *http.Request, rb *rollback, rule Rule, wr http.ResponseWriter) bool {
+	err := ui.apply(rq, rb, func() error {
+		return ui.storage.Put(rq.Context(), rule)
+	})
+	if err != nil {
+		sendMutationError(wr, err)
+		return false
+	}
+	return true
+}
+
+// reset stats: stats/{url} (DELETE) resets single service, stats/ (DELETE) resets all services.
+func (ui *basicUI) resetStats(wr http.ResponseWriter, rq *http.Request) {
+	if rq.Method != http.MethodDelete {
+		wr.Header().Set("Allow", "DELETE")
+		http.Error(wr, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	stats, ok := ui.stats.(StatReset)
+	if !ok {
+		http.Error(wr, "stats don't support reset", http.StatusNotImplemented)
+		return
+	}
+	path := strings.TrimPrefix(strings.TrimPrefix(rq.URL.Path, "/"), statsPath)
+	var err error
+	if service := strings.TrimPrefix(path, "/"); service == "" {
+		err = stats.ResetAll()
+	} else {
+		err = stats.Reset(service)
+	}
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
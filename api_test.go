@@ -0,0 +1,98 @@
+package redirect
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testUI creates UI (API) handler with in-memory storage and stats.
+func testUI(t testing.TB) (http.Handler, *JSONStorage) {
+	t.Helper()
+	storage := &JSONStorage{}
+	stats := InMemoryStats()
+	eng, err := NewEngine(storage, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = eng.Close() })
+	return DefaultUI(storage, stats, eng, "10100"), storage
+}
+
+func TestCreateRuleConflict(t *testing.T) {
+	ui, storage := testUI(t)
+
+	create := func(body string) int {
+		rec := httptest.NewRecorder()
+		ui.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+rulesPath, strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := create(`{"url": "promo", "location": "https://example.com/first"}`); code != http.StatusCreated {
+		t.Fatalf("create: status %d, want %d", code, http.StatusCreated)
+	}
+	if code := create(`{"url": "promo", "location": "https://example.com/second"}`); code != http.StatusConflict {
+		t.Fatalf("create again: status %d, want %d", code, http.StatusConflict)
+	}
+	rules, err := storage.All(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].LocationTemplate != "https://example.com/first" {
+		t.Errorf("storage changed by conflicting create: %+v", rules)
+	}
+}
+
+func TestResetStats(t *testing.T) {
+	storage := &JSONStorage{}
+	stats := InMemoryStats()
+	eng := NewTestEngine(nil)
+	defer eng.Close()
+	ui := DefaultUI(storage, stats, eng, "10100")
+	for _, service := range []string{"promo", "promo", "other"} {
+		stats.Touch(service, false)
+	}
+
+	reset := func(path string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		ui.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, path, nil))
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("reset %s: status %d, want %d", path, rec.Code, http.StatusNoContent)
+		}
+	}
+	count := func(service string) int64 {
+		value, err := stats.Count(service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	reset("/" + statsPath + "/promo")
+	if count("promo") != 0 || count("other") != 1 {
+		t.Fatalf("after reset of service: promo=%d other=%d", count("promo"), count("other"))
+	}
+	reset("/" + statsPath + "/")
+	if count("other") != 0 {
+		t.Fatalf("after reset of all: other=%d", count("other"))
+	}
+}
+
+func TestLegacyRuleNames(t *testing.T) {
+	ui, storage := testUI(t)
+	for _, name := range []string{"stats", "resolve", "config", "status", "rules", "rules/promo"} {
+		if err := storage.Put(context.Background(), Rule{URL: name, LocationTemplate: "https://example.com/" + name}); err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		ui.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+name, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "https://example.com/"+name) {
+			t.Errorf("rule named %s: status %d, body %q", name, rec.Code, rec.Body.String())
+		}
+	}
+}
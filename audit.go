@@ -0,0 +1,123 @@
+package redirect
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Audit actions.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// AuditEntry describes single change of rule made by UI or API.
+type AuditEntry struct {
+	Time   time.Time `json:"ts"`
+	Action string    `json:"action"` // one of AuditCreate, AuditUpdate, AuditDelete
+	URL    string    `json:"url"`
+	Before *Rule     `json:"before,omitempty"` // nil for created rule
+	After  *Rule     `json:"after,omitempty"`  // nil for removed rule
+	Actor  string    `json:"actor,omitempty"`  // authenticated user (see Actor), empty if auth is disabled
+}
+
+// AuditLog is sink of rule changes. Record should be safe for concurrent use.
+type AuditLog interface {
+	Record(entry AuditEntry) error
+}
+
+// JSONAuditLog writes each entry as single line of JSON.
+func JSONAuditLog(w io.Writer) AuditLog {
+	return &jsonAudit{writer: w}
+}
+
+type jsonAudit struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func (ja *jsonAudit) Record(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	ja.lock.Lock()
+	defer ja.lock.Unlock()
+	_, err = ja.writer.Write(append(data, '\n'))
+	return err
+}
+
+type actorKey struct{}
+
+// Actor returns name of user authenticated by Protect: user name for basic auth or "token" for bearer token.
+// Empty if request was not authenticated.
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// audit changes of rules from snapshot to current state of storage.
+func (ui *basicUI) audit(ctx context.Context, rb *rollback) {
+	if ui.auditLog == nil {
+		return
+	}
+	current := make(map[string]*Rule, len(rb.rules))
+	if rb.full {
+		rules, err := ui.storage.All(ctx)
+		if err != nil {
+			slog.Error("failed read rules for audit", "err", err)
+			return
+		}
+		for _, rule := range rules {
+			current[rule.URL] = rule
+		}
+	} else {
+		for url := range rb.rules {
+			rule, exists, err := ui.storage.Get(ctx, url)
+			if err != nil {
+				slog.Error("failed read rule for audit", "url", url, "err", err)
+				return
+			}
+			if exists {
+				current[url] = &rule
+			}
+		}
+	}
+	var urls = make([]string, 0, len(rb.rules)+len(current))
+	for url := range rb.rules {
+		urls = append(urls, url)
+	}
+	for url := range current {
+		if _, known := rb.rules[url]; !known {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	now := time.Now().UTC()
+	actor := Actor(ctx)
+	for _, url := range urls {
+		entry := AuditEntry{Time: now, URL: url, Before: rb.rules[url], After: current[url], Actor: actor}
+		switch {
+		case entry.Before == nil && entry.After == nil:
+			continue
+		case entry.Before == nil:
+			entry.Action = AuditCreate
+		case entry.After == nil:
+			entry.Action = AuditDelete
+		case reflect.DeepEqual(entry.Before, entry.After):
+			continue
+		default:
+			entry.Action = AuditUpdate
+		}
+		if err := ui.auditLog.Record(entry); err != nil {
+			slog.Error("failed write audit log", "url", url, "action", entry.Action, "err", err)
+		}
+	}
+}
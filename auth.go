@@ -0,0 +1,67 @@
+package redirect
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Credentials of UI and API. Basic auth is checked if User is set, bearer token is checked if Token is set. Request
+// is allowed if any of configured methods succeeded.
+type Credentials struct {
+	User     string
+	Password string
+	Token    string
+}
+
+// Enabled returns true if any auth method configured.
+func (c Credentials) Enabled() bool {
+	return c.User != "" || c.Token != ""
+}
+
+// Protect wraps handler by authentication. Unauthorized requests get 401 with WWW-Authenticate header. Handler is
+// returned as-is if credentials are not configured.
+func Protect(next http.Handler, creds Credentials) http.Handler {
+	if !creds.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(wr http.ResponseWriter, rq *http.Request) {
+		if actor, ok := creds.check(rq); ok {
+			next.ServeHTTP(wr, rq.WithContext(context.WithValue(rq.Context(), actorKey{}, actor)))
+			return
+		}
+		if creds.User != "" {
+			wr.Header().Add("WWW-Authenticate", `Basic realm="redirect", charset="UTF-8"`)
+		}
+		if creds.Token != "" {
+			wr.Header().Add("WWW-Authenticate", `Bearer realm="redirect"`)
+		}
+		http.Error(wr, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// check credentials of request and return name of authenticated actor.
+func (c Credentials) check(rq *http.Request) (string, bool) {
+	if c.Token != "" {
+		if token := strings.TrimPrefix(rq.Header.Get("Authorization"), "Bearer "); token != rq.Header.Get("Authorization") {
+			if secureEqual(token, c.Token) {
+				return "token", true
+			}
+		}
+	}
+	if c.User != "" {
+		if user, password, ok := rq.BasicAuth(); ok {
+			// check both to not leak which one is wrong
+			validUser := secureEqual(user, c.User)
+			validPassword := secureEqual(password, c.Password)
+			return c.User, validUser && validPassword
+		}
+	}
+	return "", false
+}
+
+// constant-time comparison of strings.
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,121 @@
+package redirect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Actions of bulk API.
+const (
+	BulkDelete  = "delete"
+	BulkEnable  = "enable"
+	BulkDisable = "disable"
+)
+
+// BulkRequest is body of bulk API request: action applied to all listed rules.
+type BulkRequest struct {
+	Action string   `json:"action"` // one of BulkDelete, BulkEnable, BulkDisable
+	URLs   []string `json:"urls"`
+}
+
+// BulkResult is outcome of bulk action for single rule. Status is the same as for single rule request:
+// 204 if changed, 404 if rule not exists and 500 if storage failed.
+type BulkResult struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bulk applies action to rules and reloads engine once after all changes. Storages implementing Batcher apply all
+// changes in one transaction, so if storage failed no rule is changed (all existing rules are reported as 500).
+// Other storages change rules one by one: storage errors are reported per rule, other rules are still changed.
+// If engine can't load new rules, all changes are rolled back (409).
+func (ui *basicUI) bulk(wr http.ResponseWriter, rq *http.Request) {
+	if rq.Method != http.MethodPost {
+		wr.Header().Set("Allow", "POST")
+		http.Error(wr, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var request BulkRequest
+	if err := json.NewDecoder(rq.Body).Decode(&request); err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch request.Action {
+	case BulkDelete, BulkEnable, BulkDisable:
+	default:
+		http.Error(wr, fmt.Sprintf("unknown action %q", request.Action), http.StatusBadRequest)
+		return
+	}
+	rb, err := snapshotRules(rq.Context(), ui.storage, request.URLs...)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var results = make([]BulkResult, 0, len(request.URLs))
+	err = ui.apply(rq, rb, func() error {
+		if batcher, ok := ui.storage.(Batcher); ok {
+			results = bulkBatch(rq.Context(), batcher, request, rb)
+			return nil
+		}
+		for _, url := range request.URLs {
+			result := BulkResult{URL: url, Status: http.StatusNoContent}
+			if rb.rules[url] == nil {
+				result.Status = http.StatusNotFound
+			} else if err := bulkAction(rq.Context(), ui.storage, request.Action, rb.rules[url]); err != nil {
+				result.Status = http.StatusInternalServerError
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		sendMutationError(wr, err)
+		return
+	}
+	sendJSON(results, wr)
+}
+
+// apply action to existing rules in one batch of storage.
+func bulkBatch(ctx context.Context, batcher Batcher, request BulkRequest, rb *rollback) []BulkResult {
+	var put []Rule
+	var remove []string
+	for _, url := range request.URLs {
+		rule := rb.rules[url]
+		switch {
+		case rule == nil:
+		case request.Action == BulkDelete:
+			remove = append(remove, url)
+		default:
+			cp := *rule
+			cp.Disabled = request.Action == BulkDisable
+			put = append(put, cp)
+		}
+	}
+	err := batcher.Batch(ctx, put, remove)
+	var results = make([]BulkResult, 0, len(request.URLs))
+	for _, url := range request.URLs {
+		result := BulkResult{URL: url, Status: http.StatusNoContent}
+		if rb.rules[url] == nil {
+			result.Status = http.StatusNotFound
+		} else if err != nil {
+			result.Status = http.StatusInternalServerError
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// apply action to existing rule.
+func bulkAction(ctx context.Context, storage Storage, action string, rule *Rule) error {
+	if action == BulkDelete {
+		return storage.Delete(ctx, rule.URL)
+	}
+	cp := *rule
+	cp.Disabled = action == BulkDisable
+	return storage.Put(ctx, cp)
+}
@@ -0,0 +1,99 @@
+package redirect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// storage with failing batch.
+type failedBatch struct {
+	*JSONStorage
+}
+
+func (failedBatch) Batch(context.Context, []Rule, []string) error {
+	return errors.New("database is down")
+}
+
+// bulkRequest sends bulk request to UI over storage and returns results.
+func bulkRequest(t *testing.T, storage Storage, body string) []BulkResult {
+	t.Helper()
+	stats := InMemoryStats()
+	eng, err := NewEngine(storage, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = eng.Close() }) // engine closes storage
+	if err := eng.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	DefaultUI(storage, stats, eng, "10100").ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+bulkPath, strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bulk: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var results []BulkResult
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestBulkTransactional(t *testing.T) {
+	ctx := context.Background()
+	storage := testSQLite(t,
+		Rule{URL: "a", LocationTemplate: "https://example.com/a"},
+		Rule{URL: "b", LocationTemplate: "https://example.com/b"},
+	)
+	results := bulkRequest(t, storage, `{"action": "disable", "urls": ["a", "b", "missed"]}`)
+	want := []int{http.StatusNoContent, http.StatusNoContent, http.StatusNotFound}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("%s: status %d, want %d", result.URL, result.Status, want[i])
+		}
+	}
+	for _, url := range []string{"a", "b"} {
+		if rule, _, _ := storage.Get(ctx, url); !rule.Disabled {
+			t.Errorf("rule %s is not disabled", url)
+		}
+	}
+}
+
+func TestBulkFailedBatch(t *testing.T) {
+	ctx := context.Background()
+	storage := failedBatch{&JSONStorage{}}
+	for _, url := range []string{"a", "b"} {
+		if err := storage.Put(ctx, Rule{URL: url, LocationTemplate: "https://example.com/" + url}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results := bulkRequest(t, storage, `{"action": "delete", "urls": ["a", "b", "missed"]}`)
+	want := []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusNotFound}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("%s: status %d, want %d", result.URL, result.Status, want[i])
+		}
+	}
+	if rules, _ := storage.All(ctx); len(rules) != 2 {
+		t.Errorf("rules changed by failed batch: %d rules left", len(rules))
+	}
+}
+
+func TestBulkPerRule(t *testing.T) {
+	ctx := context.Background()
+	storage := &JSONStorage{}
+	if err := storage.Put(ctx, Rule{URL: "a", LocationTemplate: "https://example.com/a"}); err != nil {
+		t.Fatal(err)
+	}
+	results := bulkRequest(t, storage, `{"action": "delete", "urls": ["a", "missed"]}`)
+	if len(results) != 2 || results[0].Status != http.StatusNoContent || results[1].Status != http.StatusNotFound {
+		t.Errorf("unexpected results: %+v", results)
+	}
+	if _, exists, _ := storage.Get(ctx, "a"); exists {
+		t.Error("rule a is not removed")
+	}
+}
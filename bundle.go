@@ -0,0 +1,99 @@
+package redirect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ImportMode defines how imported rules are combined with existing.
+type ImportMode int
+
+const (
+	ImportMerge   ImportMode = iota // add or replace imported rules, keep others
+	ImportReplace                   // remove rules which are not in the bundle
+)
+
+// Export all rules from storage as JSON bundle. Bundle has the same format as JSONStorage file.
+func Export(ctx context.Context, storage Storage, w io.Writer) error {
+	rules, err := storage.All(ctx)
+	if err != nil {
+		return fmt.Errorf("export: read rules: %w", err)
+	}
+	var bundle = make(map[string]json.RawMessage, len(rules))
+	for _, rule := range rules {
+		value, err := encodeJSONRule(rule)
+		if err != nil {
+			return fmt.Errorf("export: encode rule %v: %w", rule.URL, err)
+		}
+		bundle[rule.URL] = value
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(bundle); err != nil {
+		return fmt.Errorf("export: write bundle: %w", err)
+	}
+	return nil
+}
+
+// Import rules from JSON bundle (see Export) to storage. All rules are validated before any changes.
+func Import(ctx context.Context, storage Storage, r io.Reader, mode ImportMode) error {
+	rules, err := readBundle(r, nil, false)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+	}
+	if mode == ImportReplace {
+		existing, err := storage.All(ctx)
+		if err != nil {
+			return fmt.Errorf("import: read rules: %w", err)
+		}
+		for _, rule := range existing {
+			if _, keep := rules[rule.URL]; keep {
+				continue
+			}
+			if err := storage.Delete(ctx, rule.URL); err != nil {
+				return fmt.Errorf("import: remove rule %v: %w", rule.URL, err)
+			}
+		}
+	}
+	for _, rule := range rules {
+		if err := storage.Put(ctx, *rule); err != nil {
+			return fmt.Errorf("import: save rule %v: %w", rule.URL, err)
+		}
+	}
+	return nil
+}
+
+// read bundle as rules keyed by URL. If kept is not nil, comments (keys with jsonComment prefix) are put to it as-is,
+// the same as entries which can't be decoded as rules if skipInvalid is set (otherwise they fail whole bundle).
+func readBundle(r io.Reader, kept map[string]json.RawMessage, skipInvalid bool) (map[string]*Rule, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("parse bundle: %w", err)
+	}
+	var rules = make(map[string]*Rule, len(raw))
+	for url, value := range raw {
+		if kept != nil && strings.HasPrefix(url, jsonComment) {
+			kept[url] = value
+			continue
+		}
+		rule, err := decodeJSONRule(value)
+		if err != nil && kept != nil && skipInvalid {
+			kept[url] = value
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse rule %v: %w", url, err)
+		}
+		rule.URL = url
+		rules[url] = rule
+	}
+	return rules, nil
+}
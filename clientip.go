@@ -0,0 +1,72 @@
+package redirect
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IP of client. If direct peer is trusted proxy, the nearest untrusted address from Forwarded (RFC 7239) or
+// X-Forwarded-For header is used.
+func (eng *engine) clientIP(rq *http.Request) string {
+	peer := rq.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	if !eng.trustedProxy(peer) {
+		return peer
+	}
+	hops := forwardedFor(rq.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = strings.Split(strings.Join(rq.Header.Values("X-Forwarded-For"), ","), ",")
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		client = hop
+		if !eng.trustedProxy(hop) {
+			break
+		}
+	}
+	return client
+}
+
+func (eng *engine) trustedProxy(addr string) bool {
+	if len(eng.trusted) == 0 {
+		return false
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range eng.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addresses from for= parameters of Forwarded header, in order of hops.
+func forwardedFor(values []string) []string {
+	var ans []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, addr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				addr = strings.Trim(addr, `"`)
+				if host, _, err := net.SplitHostPort(addr); err == nil {
+					addr = host
+				}
+				ans = append(ans, strings.Trim(addr, "[]"))
+			}
+		}
+	}
+	return ans
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/reddec/redirect"
+)
+
+// command which starts servers (the same as no command).
+const serveCommand = "serve"
+
+// usage of commands, printed by -help.
+const commandsUsage = `
+Commands:
+  serve                                        start servers (default)
+  add [-status code] [-replace] url location   add rule, fails if exists unless -replace
+  del url                                      remove rule
+  list [-json]                                 print rules: URL and location separated by tab, or JSON
+  export                                       write rules bundle to stdout
+  import [-replace] [file]                     import rules bundle from file or stdin
+  import-csv [-overwrite] [file]               import rules from CSV file or stdin
+`
+
+// run offline command over storage without starting servers.
+//
+//	add [-status code] [-replace] url location - add rule
+//	del url           - remove rule
+//	list [-json]      - print rules
+//	export            - write rules bundle to stdout
+//	import [-replace] [file] - import rules bundle from file or stdin
+//	import-csv [-overwrite] [file] - import rules from CSV file or stdin
+func runCommand(ctx context.Context, storage redirect.Storage, name string, args []string) error {
+	switch name {
+	case "add":
+		cmd := flag.NewFlagSet("add", flag.ExitOnError)
+		status := cmd.Int("status", 0, "Redirect status code of rule, default of server if zero")
+		replace := cmd.Bool("replace", false, "Replace existing rule instead of failing")
+		_ = cmd.Parse(args)
+		if cmd.NArg() != 2 {
+			return errors.New("usage: add [-status code] [-replace] url location")
+		}
+		rule := redirect.Rule{URL: cmd.Arg(0), LocationTemplate: cmd.Arg(1), Status: *status}
+		if *replace {
+			return storage.Put(ctx, rule)
+		}
+		return redirect.CreateRule(ctx, storage, rule)
+	case "del":
+		if len(args) != 1 {
+			return errors.New("usage: del url")
+		}
+		_, exists, err := storage.Get(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("rule %q not found", args[0])
+		}
+		return storage.Delete(ctx, args[0])
+	case "list":
+		cmd := flag.NewFlagSet("list", flag.ExitOnError)
+		asJSON := cmd.Bool("json", false, "Print rules with all attributes as JSON array")
+		_ = cmd.Parse(args)
+		rules, err := storage.All(ctx)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "    ")
+			return enc.Encode(rules)
+		}
+		out := bufio.NewWriter(os.Stdout)
+		for _, rule := range rules {
+			_, _ = fmt.Fprintf(out, "%s\t%s\n", rule.URL, rule.LocationTemplate)
+		}
+		return out.Flush()
+	case "export":
+		return redirect.Export(ctx, storage, os.Stdout)
+	case "import":
+		cmd := flag.NewFlagSet("import", flag.ExitOnError)
+		replace := cmd.Bool("replace", false, "Remove rules which are not in the bundle")
+		_ = cmd.Parse(args)
+		var input io.Reader = os.Stdin
+		if cmd.NArg() > 0 {
+			f, err := os.Open(cmd.Arg(0))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			input = f
+		}
+		mode := redirect.ImportMerge
+		if *replace {
+			mode = redirect.ImportReplace
+		}
+		return redirect.Import(ctx, storage, input, mode)
+	case "import-csv":
+		cmd := flag.NewFlagSet("import-csv", flag.ExitOnError)
+		overwrite := cmd.Bool("overwrite", false, "Replace existing rules instead of reporting them as errors")
+		_ = cmd.Parse(args)
+		var input io.Reader = os.Stdin
+		if cmd.NArg() > 0 {
+			f, err := os.Open(cmd.Arg(0))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			input = f
+		}
+		report, err := redirect.ImportCSV(ctx, storage, input, *overwrite)
+		if report != nil {
+			for _, failed := range report.Failed {
+				slog.Warn("failed import row", "line", failed.Line, "url", failed.URL, "err", failed.Error)
+			}
+			slog.Info("rules imported", "imported", report.Imported, "failed", len(report.Failed))
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
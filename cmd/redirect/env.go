@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Prefix of environment variables for flags.
+const envPrefix = "REDIRECT_"
+
+// applyEnv sets flags which are not set in command line from environment variables: flag -default-url is read from
+// REDIRECT_DEFAULT_URL. Command line flags have priority, including aliases of flag (ex: -defaultUrl set in command
+// line is not overridden by REDIRECT_DEFAULT_URL).
+func applyEnv(fs *flag.FlagSet) error {
+	var set = make(map[valueKey]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[keyOf(f)] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[keyOf(f)] || err != nil {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("environment variable %s: %w", envName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// identity of flag value: aliases of flag share the same variable.
+type valueKey struct {
+	kind reflect.Type
+	ptr  uintptr
+	name string // for values without shared variable
+}
+
+// keyOf flag: variable of value for pointers, otherwise name of flag (ex: flag.Func doesn't have aliases).
+func keyOf(f *flag.Flag) valueKey {
+	if v := reflect.ValueOf(f.Value); v.Kind() == reflect.Pointer {
+		return valueKey{kind: v.Type(), ptr: v.Pointer()}
+	}
+	return valueKey{name: f.Name}
+}
+
+// name of environment variable for flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
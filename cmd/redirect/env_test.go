@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestApplyEnvAlias(t *testing.T) {
+	fs := flag.NewFlagSet("redirect", flag.ContinueOnError)
+	defaultURL := fs.String("defaultUrl", "", "Default redirect URL")
+	fs.StringVar(defaultURL, "default-url", "", "Alias of -defaultUrl")
+	status := fs.Int("status", 301, "Redirect status")
+	t.Setenv("REDIRECT_DEFAULT_URL", "https://env.example")
+	t.Setenv("REDIRECT_STATUS", "302")
+
+	if err := fs.Parse([]string{"-defaultUrl", "https://cli.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnv(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *defaultURL != "https://cli.example" {
+		t.Errorf("default URL %q, want value from command line", *defaultURL)
+	}
+	if *status != 302 {
+		t.Errorf("status %d, want value from environment", *status)
+	}
+}
+
+func TestApplyEnvFunc(t *testing.T) {
+	fs := flag.NewFlagSet("redirect", flag.ContinueOnError)
+	var params []string
+	fs.Func("tracking-param", "Tracking parameter", func(value string) error {
+		params = append(params, value)
+		return nil
+	})
+	t.Setenv("REDIRECT_TRACKING_PARAM", "utm_medium=link")
+
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnv(fs); err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 1 || params[0] != "utm_medium=link" {
+		t.Errorf("params %v, want value from environment", params)
+	}
+}
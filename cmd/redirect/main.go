@@ -1,55 +1,489 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata" // time zones of rule schedules in minimal images
 
 	"github.com/reddec/redirect"
 )
 
 func main() {
 	uiFolder := flag.String("ui", "", "Location of custom UI files")
-	uiAddr := flag.String("ui-addr", "127.0.0.1:10101", "Address for UI")
-	configFile := flag.String("config", "./redir.json", "File to save configs")
-	bind := flag.String("bind", "0.0.0.0:10100", "Redirect address")
+	uiAddr := flag.String("ui-addr", "127.0.0.1:10101", "Address for UI (host:port or unix:/path/to.sock)")
+	configFile := flag.String("config", "./redir.json", "File to save configs, - to read rules from stdin and keep them in memory")
+	storageURI := flag.String("storage", "", "Rules storage (sqlite:///path.db, postgres://..., redis://host:port/db), by default JSON in config file")
+	statsFile := flag.String("stats", "", "File to persist stats, by default stats kept in memory only")
+	statsFlush := flag.Duration("stats-flush", 30*time.Second, "Interval of saving stats to file, 0 disables periodic saving (stats are saved on exit and SIGUSR1)")
+	statsSnapshot := flag.String("stats-snapshot", "", "File to write snapshot of stats on SIGUSR1 (stats file is also flushed)")
+	statsBucket := flag.Duration("stats-bucket", redirect.DefaultBucketSize, "Granularity of stats time series (ex: 1h or 24h)")
+	statsBuckets := flag.Int("stats-buckets", redirect.DefaultBucketCount, "Number of stats time buckets kept per service, 0 disables time series")
+	redisPrefix := flag.String("redis-prefix", redirect.DefaultRedisPrefix, "Prefix of keys in Redis storage")
+	bind := flag.String("bind", "0.0.0.0:10100", "Redirect address (host:port or unix:/path/to.sock)")
+	socketMode := flag.String("socket-mode", "0660", "Octal permissions of Unix sockets of -bind and -ui-addr")
 	defaultUrl := flag.String("defaultUrl", "", "Default redirect URL")
 	urlParameter := flag.String("urlParameter", "", "This parameter will be added urls for regular users")
+	flag.StringVar(defaultUrl, "default-url", "", "Alias of -defaultUrl")
+	flag.StringVar(urlParameter, "url-param", "", "Alias of -urlParameter")
 	robots := flag.String("robots", "", "Robots user agents")
+	status := flag.Int("status", http.StatusMovedPermanently, "Redirect status code (301, 302, 307 or 308)")
+	caseInsensitive := flag.Bool("case-insensitive", false, "Match exact and wildcard rules regardless of case")
+	forwardQuery := flag.Bool("forward-query", false, "Forward query parameters of request to redirect target")
+	stickyKey := flag.String("sticky-key", redirect.DefaultStickyKey, "Query parameter or cookie name for sticky target selection")
+	expiredStatus := flag.Int("expired-status", 0, "Response status for expired rules (ex: 410), by default handled as not found")
+	watch := flag.Bool("watch", false, "Watch config file (or Redis changes) and reload rules after changes")
+	maxRules := flag.Int("max-rules", redirect.DefaultMaxRules, "Maximum number of rules, 0 means unlimited")
+	maxTemplateSize := flag.Int("max-template-size", redirect.DefaultMaxTemplateSize, "Maximum size of rule template in bytes, 0 means unlimited")
+	metricsByTag := flag.Bool("metrics-by-tag", false, "Label hits in metrics by first tag of rule instead of rule URL")
+	metricsServices := flag.Int("metrics-services", redirect.DefaultMetricsServiceLimit, "Maximum number of distinct service labels in metrics, 0 means unlimited")
+	webhook := flag.String("webhook", "", "URL which receives POST with JSON event after each redirect")
+	tlsCert := flag.String("tls-cert", "", "Certificate file to serve redirects over HTTPS")
+	tlsKey := flag.String("tls-key", "", "Private key file of -tls-cert")
+	tlsHosts := flag.String("tls-hosts", "", "Comma separated host names to get certificates automatically (Let's Encrypt)")
+	tlsCache := flag.String("tls-cache", "./certs", "Directory to cache automatic certificates")
+	acmeAddr := flag.String("acme-addr", "", "Address for ACME HTTP challenges and redirect to HTTPS (ex: :80), used with -tls-hosts")
+	authUser := flag.String("auth-user", "", "User name of basic auth for UI and API")
+	authPassword := flag.String("auth-password", "", "Password of basic auth for UI and API")
+	authToken := flag.String("auth-token", "", "Bearer token for UI and API")
+	rateLimit := flag.Float64("rate-limit", 0, "Maximum requests per second from single client IP, 0 means unlimited")
+	rateBurst := flag.Int("rate-burst", 10, "Maximum burst of requests from single client IP, used with -rate-limit")
+	rateClients := flag.Int("rate-clients", redirect.DefaultRateLimitClients, "Maximum number of clients tracked by rate limiter")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma separated IPs or CIDRs of proxies allowed to set X-Forwarded-For")
+	templateFallback := flag.Bool("template-fallback", false, "Handle requests with failed location templates as missed (default URL) instead of 500")
+	cacheControl := flag.String("cache-control", "", "Cache-Control header of redirects (ex: max-age=3600 or no-store)")
+	regularUsers := flag.String("regular-users", "", "User agents (separated by |) treated as regular users even if they match robots")
+	robotsFile := flag.String("robots-file", "", "File with robots user agents (one per line), merged with -robots and reloaded with rules")
+	robotsTxt := flag.String("robots-txt", redirect.DefaultRobotsTxt, "Content of /robots.txt on redirect server, empty to handle it as regular link")
+	robotsTxtFile := flag.String("robots-txt-file", "", "File with content of /robots.txt (overrides -robots-txt)")
+	reloadTimeout := flag.Duration("reload-timeout", 30*time.Second, "Timeout of loading rules from storage")
+	auditLog := flag.String("audit-log", "", "File to append JSON log of rule changes made by UI and API, - for stdout")
+	pathPrefix := flag.String("path-prefix", "", "Path prefix of redirects (ex: /r) stripped before matching rules")
+	publicURL := flag.String("public-url", "", "Public base URL of redirects (ex: https://go.example.com) for QR codes")
+	notFoundPage := flag.String("not-found-page", "", "HTML template file of page for missed requests (if -defaultUrl is not set)")
+	notFoundStatus := flag.Int("not-found-status", http.StatusNotFound, "Response status for missed requests")
+	refreshParam := flag.String("refresh-param", "", "Query parameter to get HTML page (meta refresh) instead of redirect, empty to disable")
+	dryRunParam := flag.String("dry-run-param", redirect.DefaultDryRunParam, "Query parameter to show target instead of redirect, empty to disable")
+	trackingParams := make(url.Values)
+	flag.Func("tracking-param", "Tracking parameter `key=value` added for regular users (with -urlParameter), could be repeated", func(value string) error {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return errors.New("should be key=value")
+		}
+		trackingParams.Add(key, val)
+		return nil
+	})
+	sourceParam := flag.String("source-param", "", "Tracking parameter with URL of matched rule (ex: src), empty to disable")
+	consentCookie := flag.String("consent-cookie", "", "Add tracking parameter only if request has this cookie (with -consent-value)")
+	consentValue := flag.String("consent-value", "", "Accepted value of consent cookie, any non-empty if not set")
+	allowedTargets := flag.String("allowed-targets", "", "Comma separated hosts (example.com or *.example.com) allowed in redirect targets, any if empty")
+	allowedSchemes := flag.String("allowed-schemes", "", "Comma separated schemes (ex: https) allowed in redirect targets, any if empty")
+	refererNotFound := flag.Bool("referer-not-found", false, "Handle requests blocked by rule referers as missed (default URL) instead of 403")
+	geoIP := flag.String("geoip", "", "MaxMind database file (GeoIP2/GeoLite2 Country or City) to resolve client country")
+	collapseSlashes := flag.Bool("collapse-slashes", false, "Replace duplicated slashes in request path by single one before matching")
+	trailingSlash := flag.Bool("trailing-slash", false, "Treat trailing slash of request path as significant")
+	rawPath := flag.Bool("raw-path", false, "Match rules against escaped request path instead of URL-decoded one")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logSample := flag.Int("log-sample", 0, "Maximum number of identical warnings and errors of requests per -log-sample-period (ex: 10), 0 means unlimited")
+	logSamplePeriod := flag.Duration("log-sample-period", time.Minute, "Period of -log-sample")
+	strict := flag.Bool("strict", false, "Fail loading of rules if any rule is invalid instead of skipping it (always set by -check)")
+	check := flag.Bool("check", false, "Validate configuration and rules and exit without serving")
+	var limits serverLimits
+	flag.DurationVar(&limits.readHeader, "read-header-timeout", 10*time.Second, "Maximum time to read request headers, 0 means no timeout")
+	flag.DurationVar(&limits.read, "read-timeout", 30*time.Second, "Maximum time to read whole request including body, 0 means no timeout")
+	flag.DurationVar(&limits.write, "write-timeout", 60*time.Second, "Maximum time to write response after request headers read, 0 means no timeout")
+	flag.DurationVar(&limits.idle, "idle-timeout", 120*time.Second, "Maximum time to wait for next request on keep-alive connection, 0 means no timeout")
+	flag.BoolVar(&limits.h2c, "h2c", false, "Accept HTTP/2 without TLS (prior knowledge), ex: behind reverse proxy")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "Maximum time to finish active requests on shutdown")
 
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s [flags] [command]:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), commandsUsage)
+		fmt.Fprintf(flag.CommandLine.Output(), "\nEach flag can be set by environment variable %s<FLAG> (ex: %s), flags have priority.\n", envPrefix, envName("default-url"))
+	}
 	flag.Parse()
+	if err := applyEnv(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *logFormat == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	}
 
-	// get redirect port for UI
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		slog.Error("invalid socket mode", "mode", *socketMode, "err", err)
+		os.Exit(1)
+	}
+	if *authUser != "" && *authPassword == "" {
+		slog.Error("empty password of basic auth, set -auth-password", "user", *authUser)
+		os.Exit(1)
+	}
+
+	// get redirect port for UI, empty for Unix socket (-public-url should be used then)
 	_, port, _ := net.SplitHostPort(*bind)
 
-	// init defaults
-	stats := redirect.InMemoryStats()
+	*strict = *strict || *check
+	storage, err := openStorage(*storageURI, *configFile, *redisPrefix, *strict)
+	if err != nil {
+		slog.Error("failed open storage", "err", err)
+		os.Exit(1)
+	}
+	loadCtx, cancelLoad := context.WithTimeout(context.Background(), *reloadTimeout)
+	err = storage.Reload(loadCtx)
+	cancelLoad()
+	if err != nil {
+		slog.Error("failed load rules", "err", err)
+		os.Exit(1)
+	}
 
-	storage := &redirect.JSONStorage{FileName: *configFile}
-	storage.Reload()
+	// offline commands
+	if command := flag.Arg(0); command != "" && command != serveCommand {
+		err := runCommand(context.Background(), storage, command, flag.Args()[1:])
+		if closer, ok := storage.(io.Closer); ok {
+			_ = closer.Close()
+		}
+		if err != nil {
+			slog.Error("command failed", "command", flag.Arg(0), "err", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	engine := redirect.DefaultEngine(storage, stats, *defaultUrl, *urlParameter, *robots)
-	engine.Reload()
+	if *statsBucket <= 0 || *statsBuckets < 0 {
+		slog.Error("stats bucket should be positive and number of buckets non-negative", "bucket", *statsBucket, "buckets", *statsBuckets)
+		os.Exit(1)
+	}
+	buckets := redirect.WithBuckets(*statsBucket, *statsBuckets)
+	var stats = redirect.InMemoryStats(buckets)
+	if *statsFile != "" && !*check {
+		fileStats, err := redirect.NewJSONStats(*statsFile, *statsFlush, buckets)
+		if err != nil {
+			slog.Error("failed load stats", "file", *statsFile, "err", err)
+			os.Exit(1)
+		}
+		stats = fileStats
+	}
+
+	var options = []redirect.Option{
+		redirect.WithDefaultURL(*defaultUrl),
+		redirect.WithTrackingParam(*urlParameter),
+		redirect.WithRobots(strings.Split(*robots, "|")...),
+		redirect.WithStatus(*status),
+		redirect.WithPathPrefix(*pathPrefix),
+	}
+	if *tlsHosts != "" {
+		options = append(options, redirect.WithHosts(strings.Split(*tlsHosts, ",")...))
+	}
+	if public, err := url.Parse(*publicURL); err == nil && public.Host != "" {
+		options = append(options, redirect.WithHosts(public.Host))
+	}
+	if *caseInsensitive {
+		options = append(options, redirect.WithCaseInsensitive())
+	}
+	if len(trackingParams) > 0 {
+		options = append(options, redirect.WithTrackingParams(trackingParams))
+	}
+	if *sourceParam != "" {
+		options = append(options, redirect.WithSourceParam(*sourceParam))
+	}
+	if *consentCookie != "" {
+		options = append(options, redirect.WithConsentCookie(*consentCookie, *consentValue))
+	}
+	if *allowedTargets != "" {
+		options = append(options, redirect.WithAllowedTargets(strings.Split(*allowedTargets, ",")...))
+	}
+	if *allowedSchemes != "" {
+		options = append(options, redirect.WithAllowedSchemes(strings.Split(*allowedSchemes, ",")...))
+	}
+	if *refererNotFound {
+		options = append(options, redirect.WithRefererNotFound())
+	}
+	if *geoIP != "" {
+		options = append(options, redirect.WithGeoIP(*geoIP))
+	}
+	if *collapseSlashes {
+		options = append(options, redirect.WithCollapseSlashes())
+	}
+	if *trailingSlash {
+		options = append(options, redirect.WithTrailingSlash())
+	}
+	if *rawPath {
+		options = append(options, redirect.WithRawPath())
+	}
+	if *forwardQuery {
+		options = append(options, redirect.WithForwardQuery())
+	}
+	options = append(options, redirect.WithStickyKey(*stickyKey), redirect.WithDryRunParam(*dryRunParam), redirect.WithRefreshParam(*refreshParam))
+	options = append(options, redirect.WithMetricsServiceLimit(*metricsServices))
+	options = append(options, redirect.WithLogSampling(*logSample, *logSamplePeriod))
+	if *strict {
+		options = append(options, redirect.WithStrictRules())
+	}
+	if *metricsByTag {
+		options = append(options, redirect.WithMetricsByTag())
+	}
+	options = append(options, redirect.WithMaxRules(*maxRules), redirect.WithMaxTemplateSize(*maxTemplateSize))
+	if *webhook != "" {
+		options = append(options, redirect.WithWebhook(*webhook))
+	}
+	if *rateLimit > 0 {
+		options = append(options, redirect.WithRateLimit(*rateLimit, *rateBurst), redirect.WithRateLimitClients(*rateClients))
+	}
+	if *trustedProxies != "" {
+		networks, err := parseNetworks(*trustedProxies)
+		if err != nil {
+			slog.Error("failed parse trusted proxies", "err", err)
+			os.Exit(1)
+		}
+		options = append(options, redirect.WithTrustedProxies(networks...))
+	}
+	if *robotsTxtFile != "" {
+		content, err := os.ReadFile(*robotsTxtFile)
+		if err != nil {
+			slog.Error("failed read robots.txt", "err", err)
+			os.Exit(1)
+		}
+		*robotsTxt = string(content)
+	}
+	options = append(options, redirect.WithRobotsTxt(*robotsTxt))
+	if *templateFallback {
+		options = append(options, redirect.WithTemplateFallback())
+	}
+	if *cacheControl != "" {
+		options = append(options, redirect.WithCacheControl(*cacheControl))
+	}
+	if *regularUsers != "" {
+		options = append(options, redirect.WithRegularUsers(strings.Split(*regularUsers, "|")...))
+	}
+	if *robotsFile != "" {
+		options = append(options, redirect.WithRobotsFile(*robotsFile))
+	}
+	if *notFoundPage != "" {
+		content, err := os.ReadFile(*notFoundPage)
+		if err != nil {
+			slog.Error("failed read not found page", "err", err)
+			os.Exit(1)
+		}
+		options = append(options, redirect.WithNotFoundPage(string(content)))
+	}
+	options = append(options, redirect.WithNotFoundStatus(*notFoundStatus))
+	if *expiredStatus != 0 {
+		options = append(options, redirect.WithExpiredStatus(*expiredStatus))
+	}
 
-	ui := redirect.DefaultUI(storage, stats, engine, port)
+	engine, err := redirect.NewEngine(storage, stats, options...)
+	if err != nil {
+		slog.Error("failed create engine", "err", err)
+		os.Exit(1)
+	}
+	loadCtx, cancelLoad = context.WithTimeout(context.Background(), *reloadTimeout)
+	err = engine.Reload(loadCtx)
+	cancelLoad()
+	if err != nil {
+		_ = engine.Close()
+		os.Exit(1) // error logged by engine
+	}
+	if *check {
+		_ = engine.Close()
+		slog.Info("configuration is valid")
+		return
+	}
 
-	go func() {
-		panic(http.ListenAndServe(*bind, engine))
-	}()
+	uiOptions := []redirect.UIOption{redirect.WithPublicURL(*publicURL)}
+	switch *auditLog {
+	case "":
+	case "-":
+		uiOptions = append(uiOptions, redirect.WithAuditLog(redirect.JSONAuditLog(os.Stdout)))
+	default:
+		f, err := os.OpenFile(*auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			_ = engine.Close()
+			slog.Error("failed open audit log", "file", *auditLog, "err", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		uiOptions = append(uiOptions, redirect.WithAuditLog(redirect.JSONAuditLog(f)))
+	}
+	ui := redirect.DefaultUI(storage, stats, engine, port, uiOptions...)
 
 	static := http.FileServer(http.FS(redirect.DefaultUIStatic()))
 	if *uiFolder != "" {
 		static = http.FileServer(http.Dir(*uiFolder))
 	}
-	http.Handle("/ui/", static)
-	http.Handle("/api/", http.StripPrefix("/api/", ui))
-	http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+	creds := redirect.Credentials{User: *authUser, Password: *authPassword, Token: *authToken}
+	if !creds.Enabled() && !isLoopback(*uiAddr) {
+		slog.Warn("UI and API are not protected by auth, see -auth-user and -auth-token", "ui", *uiAddr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/ui/", redirect.Protect(static, creds))
+	mux.Handle("/api/", redirect.Protect(http.StripPrefix("/api/", ui), creds))
+	mux.Handle("/metrics", redirect.Protect(engine.Metrics(), creds))
+	mux.Handle("/healthz", redirect.HealthHandler())
+	mux.Handle("/readyz", redirect.ReadyHandler(engine))
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
 		// redirect to ui
 		http.Redirect(writer, request, "ui/", http.StatusPermanentRedirect)
 	})
-	log.Println("UI:", *uiAddr)
-	log.Println("Bind:", *bind)
-	panic(http.ListenAndServe(*uiAddr, nil))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	// reload rules on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadCtx, cancel := context.WithTimeout(ctx, *reloadTimeout)
+			if err := storage.Reload(reloadCtx); err != nil {
+				slog.Error("failed reload storage, previous rules kept", "err", err)
+			} else {
+				_ = engine.Reload(reloadCtx) // outcome logged by engine
+			}
+			cancel()
+		}
+	}()
+
+	// flush stats and write snapshot on SIGUSR1
+	usr := make(chan os.Signal, 1)
+	notifySaveStats(usr)
+	go func() {
+		for range usr {
+			saveStats(stats, *statsSnapshot)
+		}
+	}()
+
+	if changes, ok := storage.(watcher); *watch && ok {
+		go func() {
+			err := changes.Watch(ctx, func() {
+				reloadCtx, cancel := context.WithTimeout(ctx, *reloadTimeout)
+				defer cancel()
+				_ = engine.Reload(reloadCtx) // outcome logged by engine
+			})
+			if err != nil {
+				slog.Error("failed watch storage", "err", err)
+			}
+		}()
+	} else if *watch {
+		slog.Warn("watch is not supported by storage")
+	}
+
+	redirServer := limits.server(*bind, engine)
+	acme, err := setupTLS(redirServer, *tlsCert, *tlsKey, *tlsHosts, *tlsCache)
+	if err != nil {
+		slog.Error("failed setup TLS", "err", err)
+		os.Exit(1)
+	}
+	servers := []*http.Server{
+		redirServer,
+		limits.server(*uiAddr, mux),
+	}
+	if acme != nil && *acmeAddr != "" {
+		servers = append(servers, limits.server(*acmeAddr, acme))
+	}
+	var listeners = make([]net.Listener, 0, len(servers))
+	for _, srv := range servers {
+		ln, err := listen(srv.Addr, fs.FileMode(mode))
+		if err != nil {
+			for _, ln := range listeners {
+				_ = ln.Close()
+			}
+			_ = engine.Close()
+			slog.Error("failed listen", "addr", srv.Addr, "err", err)
+			os.Exit(1)
+		}
+		listeners = append(listeners, ln)
+	}
+	failed := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func(srv *http.Server, ln net.Listener) {
+			if err := serve(srv, ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				failed <- err
+			}
+		}(srv, listeners[i])
+	}
+	slog.Info("started", "ui", *uiAddr, "bind", *bind)
+
+	var exitCode int
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	case err := <-failed:
+		slog.Error("server failed", "err", err)
+		exitCode = 1
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed shutdown server", "addr", srv.Addr, "err", err)
+		}
+	}
+	if err := engine.Close(); err != nil {
+		slog.Error("failed close engine", "err", err)
+		exitCode = 1
+	}
+	cancel()
+	os.Exit(exitCode)
+}
+
+// check that address is bound to loopback interface or Unix socket only.
+func isLoopback(addr string) bool {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return true // access is limited by permissions of socket
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parse comma separated IPs or CIDRs. Single IP is network of one address.
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var ans []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			ans = append(ans, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		ans = append(ans, network)
+	}
+	return ans, nil
 }
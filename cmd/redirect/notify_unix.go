@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notify about requests to save stats (SIGUSR1).
+func notifySaveStats(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR1)
+}
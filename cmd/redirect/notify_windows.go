@@ -0,0 +1,8 @@
+package main
+
+import (
+	"os"
+)
+
+// notify about requests to save stats: SIGUSR1 is not available on Windows.
+func notifySaveStats(chan<- os.Signal) {}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// limits of connections applied to all servers. Zero timeout means no timeout.
+type serverLimits struct {
+	readHeader time.Duration
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+	h2c        bool // accept HTTP/2 without TLS (prior knowledge), ex: behind proxy
+}
+
+// create server with timeouts and protocols. HTTP/2 is enabled for TLS connections and, if allowed, for plain ones.
+func (sl *serverLimits) server(addr string, handler http.Handler) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(sl.h2c)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: sl.readHeader,
+		ReadTimeout:       sl.read,
+		WriteTimeout:      sl.write,
+		IdleTimeout:       sl.idle,
+		Protocols:         &protocols,
+	}
+}
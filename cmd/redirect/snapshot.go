@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/reddec/redirect"
+)
+
+// save stats on demand: flush persistent stats and write snapshot to file (if set).
+func saveStats(stats redirect.Stats, snapshotFile string) {
+	flushed := false
+	if flusher, ok := stats.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			slog.Error("failed flush stats", "err", err)
+		} else {
+			slog.Info("stats flushed")
+		}
+		flushed = true
+	}
+	if snapshotFile == "" {
+		if !flushed {
+			slog.Warn("stats are kept in memory only, set -stats-snapshot to save them")
+		}
+		return
+	}
+	snapshot, ok := stats.(redirect.StatSnapshot)
+	if !ok {
+		slog.Warn("stats don't support snapshots")
+		return
+	}
+	if err := snapshot.Snapshot(snapshotFile); err != nil {
+		slog.Error("failed write stats snapshot", "file", snapshotFile, "err", err)
+		return
+	}
+	slog.Info("stats snapshot saved", "file", snapshotFile)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// configure TLS of server by certificate files or by ACME (Let's Encrypt) for hosts. Returns handler of ACME HTTP-01
+// challenges (it redirects other requests to HTTPS) or nil if ACME is not used. Server is left as-is if TLS is not
+// configured.
+func setupTLS(srv *http.Server, certFile, keyFile, hosts, cacheDir string) (http.Handler, error) {
+	switch {
+	case certFile != "" || keyFile != "":
+		if hosts != "" {
+			return nil, errors.New("use either certificate files or ACME hosts")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		return nil, nil
+	case hosts != "":
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(hosts, ",")...),
+			Cache:      autocert.DirCache(cacheDir),
+		}
+		srv.TLSConfig = manager.TLSConfig()
+		return manager.HTTPHandler(nil), nil
+	default:
+		return nil, nil
+	}
+}
+
+// serve plain HTTP or HTTPS (if server has TLS config) on listener.
+func serve(srv *http.Server, ln net.Listener) error {
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
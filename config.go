@@ -0,0 +1,132 @@
+package redirect
+
+import (
+	"net/http"
+)
+
+const configPath = "_config" // effective configuration of engine
+
+// EngineConfig is effective configuration of engine after all options applied. Secrets (webhook URL, consent value)
+// are not exposed, only whether they are set.
+type EngineConfig struct {
+	DefaultURL       string            `json:"defaultUrl,omitempty"`
+	TrackingParam    string            `json:"trackingParam,omitempty"`
+	TrackingParams   map[string]string `json:"trackingParams,omitempty"`
+	SourceParam      string            `json:"sourceParam,omitempty"`
+	Robots           []string          `json:"robots"` // including robots from file
+	RobotsFile       string            `json:"robotsFile,omitempty"`
+	RegularUsers     []string          `json:"regularUsers,omitempty"`
+	Status           int               `json:"status"`
+	ExpiredStatus    int               `json:"expiredStatus,omitempty"`
+	NotFoundStatus   int               `json:"notFoundStatus"`
+	NotFoundPage     bool              `json:"notFoundPage"`
+	CaseInsensitive  bool              `json:"caseInsensitive"`
+	ForwardQuery     bool              `json:"forwardQuery"`
+	CollapseSlashes  bool              `json:"collapseSlashes"`
+	TrailingSlash    bool              `json:"trailingSlash"`
+	RawPath          bool              `json:"rawPath"`
+	PathPrefix       string            `json:"pathPrefix,omitempty"`
+	Hosts            []string          `json:"hosts,omitempty"`
+	StickyKey        string            `json:"stickyKey"`
+	DryRunParam      string            `json:"dryRunParam,omitempty"`
+	RefreshParam     string            `json:"refreshParam,omitempty"`
+	RobotsTxt        bool              `json:"robotsTxt"`
+	CacheControl     string            `json:"cacheControl,omitempty"`
+	TemplateFallback bool              `json:"templateFallback"`
+	RefererNotFound  bool              `json:"refererNotFound"`
+	ConsentCookie    string            `json:"consentCookie,omitempty"`
+	GeoIP            string            `json:"geoip,omitempty"`
+	Webhook          bool              `json:"webhook"`
+	RateLimit        float64           `json:"rateLimit,omitempty"`
+	RateBurst        int               `json:"rateBurst,omitempty"`
+	TrustedProxies   []string          `json:"trustedProxies,omitempty"`
+	MetricsLimit     int               `json:"metricsServiceLimit"`
+	MetricsByTag     bool              `json:"metricsByTag"`
+	StrictRules      bool              `json:"strictRules"`
+	LogSample        int               `json:"logSample,omitempty"`
+	LogSamplePeriod  string            `json:"logSamplePeriod,omitempty"`
+	MaxRules         int               `json:"maxRules"`
+	MaxTemplateSize  int               `json:"maxTemplateSize"`
+	AllowedTargets   []string          `json:"allowedTargets,omitempty"`
+	AllowedSchemes   []string          `json:"allowedSchemes,omitempty"`
+}
+
+// configurer is implemented by engines which can report effective configuration.
+type configurer interface {
+	config() *EngineConfig
+}
+
+func (eng *engine) config() *EngineConfig {
+	cfg := &EngineConfig{
+		DefaultURL:       eng.defaultText,
+		TrackingParam:    eng.urlParameter,
+		SourceParam:      eng.sourceParam,
+		RobotsFile:       eng.robotsFile,
+		RegularUsers:     eng.regularUsers,
+		Status:           eng.status,
+		ExpiredStatus:    eng.expired,
+		NotFoundStatus:   eng.notFoundStatus,
+		NotFoundPage:     eng.notFoundPage != nil,
+		CaseInsensitive:  eng.ignoreCase,
+		ForwardQuery:     eng.forwardQuery,
+		CollapseSlashes:  eng.collapse,
+		TrailingSlash:    eng.trailingSlash,
+		RawPath:          eng.rawPath,
+		PathPrefix:       eng.pathPrefix,
+		Hosts:            eng.hosts,
+		StickyKey:        eng.stickyKey,
+		DryRunParam:      eng.dryRunParam,
+		RefreshParam:     eng.refreshParam,
+		RobotsTxt:        eng.robotsTxt != "",
+		CacheControl:     eng.cacheControl,
+		TemplateFallback: eng.tplFallback,
+		RefererNotFound:  eng.refererNotFound,
+		ConsentCookie:    eng.consentCookie,
+		GeoIP:            eng.geoFile,
+		Webhook:          eng.webhookURL != "",
+		RateLimit:        eng.rateLimit,
+		RateBurst:        eng.rateBurst,
+		MetricsLimit:     eng.metricsLimit,
+		MetricsByTag:     eng.metricsByTag,
+		StrictRules:      eng.strict,
+		MaxRules:         eng.maxRules,
+		MaxTemplateSize:  eng.maxTemplate,
+		AllowedTargets:   eng.targetHosts,
+		AllowedSchemes:   eng.allowSchemes,
+	}
+	cfg.Robots = []string{}
+	for _, robot := range eng.robots {
+		if robot != "" {
+			cfg.Robots = append(cfg.Robots, robot)
+		}
+	}
+	cfg.Robots = append(cfg.Robots, eng.current.Load().robots...)
+	if len(eng.trackingParams) > 0 {
+		cfg.TrackingParams = make(map[string]string, len(eng.trackingParams))
+		for key := range eng.trackingParams {
+			cfg.TrackingParams[key] = eng.trackingParams.Get(key)
+		}
+	}
+	if eng.sampleLimit > 0 {
+		cfg.LogSample, cfg.LogSamplePeriod = eng.sampleLimit, eng.samplePeriod.String()
+	}
+	for _, network := range eng.trusted {
+		cfg.TrustedProxies = append(cfg.TrustedProxies, network.String())
+	}
+	return cfg
+}
+
+// effective configuration of engine (read-only).
+func (ui *basicUI) config(wr http.ResponseWriter, rq *http.Request) {
+	if rq.Method != http.MethodGet {
+		wr.Header().Set("Allow", "GET")
+		http.Error(wr, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	engine, ok := ui.engine.(configurer)
+	if !ok {
+		http.Error(wr, "engine doesn't report configuration", http.StatusNotImplemented)
+		return
+	}
+	sendJSON(engine.config(), wr)
+}
@@ -0,0 +1,100 @@
+package redirect
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Columns of CSV import. First row of CSV should be header with columns names, unknown columns are ignored.
+const (
+	CSVColumnURL      = "url"      // required
+	CSVColumnLocation = "location" // required
+	CSVColumnStatus   = "status"   // optional
+)
+
+// CSVRowError describes failed row of CSV import.
+type CSVRowError struct {
+	Line  int    `json:"line"`
+	URL   string `json:"url,omitempty"`
+	Error string `json:"error"`
+}
+
+// CSVReport is result of CSV import.
+type CSVReport struct {
+	Imported int           `json:"imported"`
+	Failed   []CSVRowError `json:"failed,omitempty"`
+}
+
+// ImportCSV creates rules from CSV (see CSVColumnURL, CSVColumnLocation, CSVColumnStatus). Invalid rows are reported
+// and skipped without aborting import. Existing rules (including duplicates in the same CSV) are replaced if overwrite
+// enabled, otherwise reported as errors. Error returned only for broken CSV or header.
+func ImportCSV(ctx context.Context, storage Storage, r io.Reader, overwrite bool) (*CSVReport, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("import csv: read header: %w", err)
+	}
+	var columns = make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{CSVColumnURL, CSVColumnLocation} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("import csv: missed column %q", required)
+		}
+	}
+	var report CSVReport
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.Failed = append(report.Failed, CSVRowError{Line: parseErr.Line, Error: parseErr.Err.Error()})
+			continue
+		} else if err != nil {
+			return &report, fmt.Errorf("import csv: %w", err)
+		}
+		line, _ := reader.FieldPos(0) // only valid for successfully read record
+		rule := Rule{
+			URL:              csvValue(record, columns, CSVColumnURL),
+			LocationTemplate: csvValue(record, columns, CSVColumnLocation),
+		}
+		if err := importCSVRule(ctx, storage, &rule, csvValue(record, columns, CSVColumnStatus), overwrite); err != nil {
+			report.Failed = append(report.Failed, CSVRowError{Line: line, URL: rule.URL, Error: err.Error()})
+			continue
+		}
+		report.Imported++
+	}
+	return &report, nil
+}
+
+func importCSVRule(ctx context.Context, storage Storage, rule *Rule, status string, overwrite bool) error {
+	if status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			return fmt.Errorf("parse status: %w", err)
+		}
+		rule.Status = code
+	}
+	if !overwrite {
+		return CreateRule(ctx, storage, *rule) // atomic for storages implementing Creator
+	}
+	return storage.Put(ctx, *rule)
+}
+
+func csvValue(record []string, columns map[string]int, name string) string {
+	idx, ok := columns[name]
+	if !ok || idx >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[idx])
+}
@@ -0,0 +1,63 @@
+package redirect
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	ctx := context.Background()
+	storage := &JSONStorage{}
+	if err := storage.Put(ctx, Rule{URL: "old", LocationTemplate: "https://example.com/old"}); err != nil {
+		t.Fatal(err)
+	}
+	input := "url,location,status\n" +
+		"promo,https://example.com/promo,302\n" +
+		"old,https://example.com/new,\n" +
+		"bad,https://example.com/bad,abc\n"
+	report, err := ImportCSV(ctx, storage, strings.NewReader(input), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 1 || len(report.Failed) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if failed := report.Failed[0]; failed.Line != 3 || failed.URL != "old" {
+		t.Errorf("unexpected failed row: %+v", failed)
+	}
+	rule, _, _ := storage.Get(ctx, "old")
+	if rule.LocationTemplate != "https://example.com/old" {
+		t.Errorf("existing rule replaced without overwrite: %q", rule.LocationTemplate)
+	}
+	rule, _, _ = storage.Get(ctx, "promo")
+	if rule.Status != 302 {
+		t.Errorf("status of imported rule %d, want 302", rule.Status)
+	}
+}
+
+func TestImportCSVBrokenRecord(t *testing.T) {
+	input := "url,location\n" +
+		"\"broken\n" +
+		"promo,https://example.com/promo\n"
+	report, err := ImportCSV(context.Background(), &JSONStorage{}, strings.NewReader(input), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failed) != 1 || report.Failed[0].Line == 0 {
+		t.Fatalf("broken record is not reported: %+v", report)
+	}
+}
+
+func TestImportCSVBareQuote(t *testing.T) {
+	input := "url,location\n" +
+		"a\"b,https://example.com/\n" +
+		"promo,https://example.com/promo\n"
+	report, err := ImportCSV(context.Background(), &JSONStorage{}, strings.NewReader(input), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 1 || len(report.Failed) != 1 || report.Failed[0].Line != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
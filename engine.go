@@ -1,27 +1,514 @@
 package redirect
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"log"
+	htmltemplate "html/template"
+	"io"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
-	"text/template"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/text/unicode/norm"
 )
 
+// Default content of /robots.txt: disallow crawling of all links.
+const DefaultRobotsTxt = "User-agent: *\nDisallow: /\n"
+
+// Number of consecutive failed reloads after which engine is not ready.
+const ReadyFailures = 3
+
+// Name of OpenTelemetry instrumentation.
+const tracerName = "github.com/reddec/redirect"
+
 type engine struct {
-	storage      Storage
-	stat         StatWriter
-	lock         sync.RWMutex
-	rules        map[string]*template.Template
-	defaultUrl   string
-	urlParameter string
-	robots       []string
+	storage         Storage
+	stat            StatWriter
+	counter         StatReader              // same as stat if it supports reading, otherwise nil
+	lock            sync.RWMutex            // guards reload status (loaded, failures, reloadErr, failedAt)
+	reloading       sync.Mutex              // serializes reloads, so older reload never replaces newer rules
+	current         atomic.Pointer[ruleSet] // loaded rules, replaced as whole on reload
+	defaultText     string                  // template of URL for missed requests, parsed after options
+	defaultUrl      *target                 // template of URL for missed requests, nil if not set
+	urlParameter    string
+	robots          []string
+	robotsFile      string // file with additional robots, reloaded with rules
+	status          int
+	ignoreCase      bool
+	forwardQuery    bool
+	expired         int    // status for expired rules, zero means same as not found
+	stickyKey       string // name of cookie or query parameter to select the same target for visitor
+	metricsLimit    int    // maximum number of distinct service labels in metrics
+	metricsByTag    bool   // label hits by first tag of rule
+	metrics         *metrics
+	logger          *slog.Logger
+	requestLog      *slog.Logger // logger of request handling, sampled if enabled
+	sampleLimit     int          // identical warnings and errors per sample period, zero means unlimited
+	samplePeriod    time.Duration
+	webhookURL      string // default webhook of rules
+	webhookQueue    int
+	hooks           *webhook
+	traces          trace.TracerProvider
+	propagator      propagation.TextMapPropagator
+	tracer          trace.Tracer
+	loaded          bool      // at least one reload succeeded
+	failures        int       // consecutive failed reloads
+	reloadErr       error     // last reload error
+	failedAt        time.Time // time of last failed reload
+	strict          bool      // fail reload on invalid rule instead of skipping it
+	rateLimit       float64
+	rateBurst       int
+	rateClients     int
+	limiter         *limiter // nil if rate limit disabled
+	trusted         []*net.IPNet
+	robotsTxt       string // content of /robots.txt, empty means not served
+	notFoundText    string // template of not found page
+	notFoundPage    *htmltemplate.Template
+	notFoundStatus  int
+	dryRunParam     string // query parameter to resolve target without redirect, empty means disabled
+	collapse        bool   // collapse duplicated slashes in path
+	trailingSlash   bool   // keep trailing slash of path
+	rawPath         bool   // match escaped path
+	geoFile         string // MaxMind database of GeoIP, empty means disabled
+	geo             *geoIP
+	refererNotFound bool       // handle requests blocked by referer as missed
+	consentCookie   string     // cookie required for tracking parameter, empty means not required
+	consentValue    string     // accepted value of consent cookie, empty means any non-empty
+	trackingParams  url.Values // tracking parameters in addition to urlParameter
+	sourceParam     string     // tracking parameter with URL of matched rule, empty means disabled
+	regularUsers    []string   // lowered user agent substrings of regular users, checked before robots
+	cacheControl    string     // Cache-Control of redirects, empty means not set
+	tplFallback     bool       // handle requests with failed templates as missed
+	refreshParam    string     // query parameter to get refresh page instead of redirect, empty means disabled
+	maxRules        int        // maximum number of rules, zero means unlimited
+	maxTemplate     int        // maximum size of template, zero means unlimited
+	hosts           []string   // lowered host names of redirect server, used to detect loops
+	pathPrefix      string     // prefix of paths stripped before matching, empty if engine mounted at root
+	targetHosts     []string   // patterns of allowed target hosts, compiled after options
+	allowHosts      *hostList  // allowed hosts of absolute targets, nil means any
+	allowSchemes    []string   // lowered allowed schemes of absolute targets, empty means any
+}
+
+// ruleSet is immutable snapshot of loaded rules. Reload builds new set and swaps pointer, so requests never wait for
+// reload and always see rules and robots of the same reload.
+type ruleSet struct {
+	rules     map[string]*route
+	fallbacks []*route         // wildcard and regex rules in order of evaluation
+	robots    []string         // robots from robotsFile
+	loadedAt  time.Time        // time of reload, zero for initial empty set
+	invalid   map[string]error // errors of invalid rules skipped by reload, by URL
+}
+
+// number of exact, wildcard and regex rules.
+func (set *ruleSet) counts() (exact, wildcards, patterns int) {
+	exact = len(set.rules)
+	for _, rt := range set.fallbacks {
+		if rt.pattern != nil {
+			patterns++
+		} else {
+			wildcards++
+		}
+	}
+	return exact, wildcards, patterns
+}
+
+// Default name of query parameter for dry-run requests (see WithDryRunParam).
+const DefaultDryRunParam = "_dryrun"
+
+// StatusHeader of HEAD and dry-run responses carries status code of real redirect (ex: 301).
+const StatusHeader = "X-Redirect-Status"
+
+// Default name of cookie or query parameter for sticky target selection.
+const DefaultStickyKey = "sticky"
+
+// Default limits of loaded rules: number of rules and size of single template in bytes.
+const (
+	DefaultMaxRules        = 100000
+	DefaultMaxTemplateSize = 64 * 1024
+)
+
+// Option of engine.
+type Option func(eng *engine)
+
+// WithCaseInsensitive makes exact and wildcard rules match paths regardless of case. Regex rules are unaffected
+// (use (?i) flag in pattern instead).
+func WithCaseInsensitive() Option {
+	return func(eng *engine) {
+		eng.ignoreCase = true
+	}
+}
+
+// WithForwardQuery merges query of incoming request into redirect target. Parameters defined by target have priority.
+func WithForwardQuery() Option {
+	return func(eng *engine) {
+		eng.forwardQuery = true
+	}
+}
+
+// WithExpiredStatus sets response status (ex: 410 Gone) for rules after their NotAfter time.
+// By default, expired rules are handled as missed.
+func WithExpiredStatus(status int) Option {
+	return func(eng *engine) {
+		eng.expired = status
+	}
+}
+
+// WithStickyKey sets name of cookie or query parameter (default is DefaultStickyKey) which value used to select the same
+// target of rule with multiple targets.
+func WithStickyKey(name string) Option {
+	return func(eng *engine) {
+		eng.stickyKey = name
+	}
+}
+
+// WithMetricsServiceLimit sets maximum number of distinct service labels in metrics (default is
+// DefaultMetricsServiceLimit). Hits of other services are counted with label _other. Non-positive limit means unlimited.
+func WithMetricsServiceLimit(limit int) Option {
+	return func(eng *engine) {
+		eng.metricsLimit = limit
+	}
+}
+
+// WithMetricsByTag labels hits in metrics by first tag of rule (label tag) instead of rule URL (label service), so
+// dashboards are grouped by campaign or category. Rules without tags are counted with label _untagged.
+func WithMetricsByTag() Option {
+	return func(eng *engine) {
+		eng.metricsByTag = true
+	}
+}
+
+// WithLogger sets structured logger of engine. By default, slog.Default() is used.
+func WithLogger(logger *slog.Logger) Option {
+	return func(eng *engine) {
+		eng.logger = logger
+	}
+}
+
+// WithStrictRules makes reload all-or-nothing: any invalid rule fails reload and previous rules are kept. By default
+// invalid rules (ex: broken template) are logged and skipped, so one bad rule doesn't stop others. Conflicting rules
+// and redirect loops always fail reload.
+func WithStrictRules() Option {
+	return func(eng *engine) {
+		eng.strict = true
+	}
+}
+
+// WithLogSampling limits logging of requests: at most limit identical warnings and errors (the same message and rule
+// or service) per period, ex: template errors of broken rule. Number of dropped records is reported by attribute
+// suppressed of the next logged one. Non-positive limit disables sampling (default).
+func WithLogSampling(limit int, period time.Duration) Option {
+	return func(eng *engine) {
+		eng.sampleLimit = limit
+		eng.samplePeriod = period
+	}
+}
+
+// WithWebhook sets URL which receives POST with WebhookEvent after each redirect. Rules may override it (see
+// Rule.Webhook). Events are sent asynchronously with retries.
+func WithWebhook(url string) Option {
+	return func(eng *engine) {
+		eng.webhookURL = url
+	}
+}
+
+// WithWebhookQueue sets maximum number of pending webhook events (default is DefaultWebhookQueue). New events are
+// dropped if queue is full.
+func WithWebhookQueue(size int) Option {
+	return func(eng *engine) {
+		eng.webhookQueue = size
+	}
 }
 
-// Create default engine based on provided storage and sink.
-func DefaultEngine(storage Storage, sink StatWriter, defaultUrl string, urlParameter string, robots string) Engine {
+// WithTracerProvider sets OpenTelemetry tracer provider for spans of requests. By default, global provider is used
+// (no-op unless configured by otel.SetTracerProvider).
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(eng *engine) {
+		eng.traces = provider
+	}
+}
+
+// WithPropagator sets propagator which extracts trace context from incoming requests. By default, W3C Trace Context
+// (traceparent header) is used.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(eng *engine) {
+		eng.propagator = propagator
+	}
+}
+
+// WithRateLimit limits number of requests per client IP: rate is number of requests per second, burst is maximum
+// number of requests at once. Requests above limit get 429 Too Many Requests. Zero rate disables limit (default).
+func WithRateLimit(rate float64, burst int) Option {
+	return func(eng *engine) {
+		eng.rateLimit = rate
+		eng.rateBurst = burst
+	}
+}
+
+// WithRateLimitClients sets maximum number of clients tracked by rate limiter (default is DefaultRateLimitClients).
+// Least recently seen clients are forgotten.
+func WithRateLimitClients(size int) Option {
+	return func(eng *engine) {
+		eng.rateClients = size
+	}
+}
+
+// WithTrustedProxies sets networks of proxies which X-Forwarded-For header is used to detect client IP. By default,
+// no proxies are trusted and header is ignored.
+func WithTrustedProxies(networks ...*net.IPNet) Option {
+	return func(eng *engine) {
+		eng.trusted = networks
+	}
+}
+
+// WithRobotsTxt sets content of /robots.txt (default is DefaultRobotsTxt). Empty content disables robots.txt, so
+// the path is handled as regular link.
+func WithRobotsTxt(content string) Option {
+	return func(eng *engine) {
+		eng.robotsTxt = content
+	}
+}
+
+// WithRobotsFile sets file with additional user agent substrings of robots (one per line, # starts comment). The file
+// is read on each Reload and merged with robots of WithRobots.
+func WithRobotsFile(fileName string) Option {
+	return func(eng *engine) {
+		eng.robotsFile = fileName
+	}
+}
+
+// WithNotFoundPage sets HTML template of page for missed requests (when default URL is not set). Template gets the same
+// data as rule location, values are escaped.
+func WithNotFoundPage(page string) Option {
+	return func(eng *engine) {
+		eng.notFoundText = page
+	}
+}
+
+// WithNotFoundStatus sets response status for missed requests (default is 404 Not Found).
+func WithNotFoundStatus(status int) Option {
+	return func(eng *engine) {
+		eng.notFoundStatus = status
+	}
+}
+
+// WithDryRunParam sets name of query parameter (default is DefaultDryRunParam) which makes request of any method to
+// behave like HEAD: target is sent in Location header with 200 OK status instead of redirect. Empty name disables it.
+func WithDryRunParam(name string) Option {
+	return func(eng *engine) {
+		eng.dryRunParam = name
+	}
+}
+
+// WithCollapseSlashes replaces duplicated slashes in request path by single one before matching: /a//b matches rule a/b.
+func WithCollapseSlashes() Option {
+	return func(eng *engine) {
+		eng.collapse = true
+	}
+}
+
+// WithTrailingSlash makes trailing slash of request path significant: /a/ matches rule a/ but not a. By default,
+// leading and trailing slashes are trimmed.
+func WithTrailingSlash() Option {
+	return func(eng *engine) {
+		eng.trailingSlash = true
+	}
+}
+
+// WithRawPath matches rules against escaped request path (as sent by client) instead of URL-decoded one:
+// /a%2Fb matches rule a%2Fb instead of a/b.
+func WithRawPath() Option {
+	return func(eng *engine) {
+		eng.rawPath = true
+	}
+}
+
+// WithGeoIP resolves country of client by MaxMind database file (GeoIP2/GeoLite2 Country or City) for GeoCountry
+// in templates and rule countries. Database is opened on engine creation. Empty file disables GeoIP.
+func WithGeoIP(file string) Option {
+	return func(eng *engine) {
+		eng.geoFile = file
+	}
+}
+
+// WithRefererNotFound handles requests blocked by rule referers as missed (default URL or not found page)
+// instead of 403 Forbidden.
+func WithRefererNotFound() Option {
+	return func(eng *engine) {
+		eng.refererNotFound = true
+	}
+}
+
+// WithConsentCookie adds tracking parameter (urlParameter) only if request has cookie with accepted value (any
+// non-empty value if accepted is empty). Without consent, redirects are done as if urlParameter were empty.
+// Empty name disables the check.
+func WithConsentCookie(name, accepted string) Option {
+	return func(eng *engine) {
+		eng.consentCookie = name
+		eng.consentValue = accepted
+	}
+}
+
+// WithTrackingParams adds parameters to target for regular users together with urlParameter. Rules could override
+// them by TrackingParams.
+func WithTrackingParams(params url.Values) Option {
+	return func(eng *engine) {
+		eng.trackingParams = params
+	}
+}
+
+// WithSourceParam adds parameter with URL of matched rule (short code, ex: src=promo) to target together with other
+// tracking parameters, so traffic of different rules to the same destination can be distinguished. Rules could
+// override or remove it by TrackingParams. Redirects to default URL are not marked.
+func WithSourceParam(name string) Option {
+	return func(eng *engine) {
+		eng.sourceParam = name
+	}
+}
+
+// WithRegularUsers treats clients which user agent contains any of substrings (case-insensitive) as regular users
+// even if they match robots, ex: internal monitoring agents.
+func WithRegularUsers(agents ...string) Option {
+	return func(eng *engine) {
+		for _, agent := range agents {
+			if agent != "" {
+				eng.regularUsers = append(eng.regularUsers, strings.ToLower(agent))
+			}
+		}
+	}
+}
+
+// WithCacheControl sets Cache-Control header (ex: max-age=3600 or no-store) of redirects. Rules could override it
+// by CacheControl. Expires header is also set for max-age. Empty value means no headers.
+func WithCacheControl(value string) Option {
+	return func(eng *engine) {
+		eng.cacheControl = value
+	}
+}
+
+// WithTemplateFallback handles requests which location template (and rule fallback, if any) failed as requests
+// without matched rule (default URL or not found page) instead of 500 Internal Server Error.
+func WithTemplateFallback() Option {
+	return func(eng *engine) {
+		eng.tplFallback = true
+	}
+}
+
+// WithRefreshParam sets query parameter (ex: _refresh=true) to respond with HTML page (meta refresh and link) instead
+// of HTTP redirect, for clients which don't follow redirects. Rules could require the page by Refresh. Empty name
+// (default) disables parameter.
+func WithRefreshParam(name string) Option {
+	return func(eng *engine) {
+		eng.refreshParam = name
+	}
+}
+
+// WithMaxRules sets maximum number of rules (including disabled) accepted by Reload (DefaultMaxRules by default).
+// Zero means unlimited.
+func WithMaxRules(limit int) Option {
+	return func(eng *engine) {
+		eng.maxRules = limit
+	}
+}
+
+// WithMaxTemplateSize sets maximum size in bytes of each template of rule (location, targets, schedule, countries,
+// languages and fallback) accepted by Reload (DefaultMaxTemplateSize by default). Zero means unlimited.
+func WithMaxTemplateSize(limit int) Option {
+	return func(eng *engine) {
+		eng.maxTemplate = limit
+	}
+}
+
+// WithHosts sets host names of redirect server (ex: go.example.com, port is ignored). Reload rejects rules with static locations which
+// redirect back to the same rules through these hosts (relative locations like /promo are always checked).
+func WithHosts(hosts ...string) Option {
+	return func(eng *engine) {
+		for _, host := range hosts {
+			eng.hosts = append(eng.hosts, strings.ToLower((&url.URL{Host: host}).Hostname()))
+		}
+	}
+}
+
+// WithPathPrefix sets path prefix (ex: /r) of engine mounted under router. Prefix is stripped before matching, requests
+// outside the prefix get 404. Not needed if prefix is already stripped (ex: by http.StripPrefix).
+func WithPathPrefix(prefix string) Option {
+	return func(eng *engine) {
+		if prefix = strings.Trim(prefix, "/"); prefix != "" {
+			eng.pathPrefix = "/" + prefix
+		} else {
+			eng.pathPrefix = ""
+		}
+	}
+}
+
+// WithAllowedTargets restricts hosts of redirect targets (after template execution) to protect from open redirects by
+// templates with request data. Patterns are host names (example.com) or sub-domains (*.example.com) in ASCII
+// (punycode). Targets with other hosts or without host (ex: javascript:) get 403 Forbidden, relative targets are
+// allowed only as paths on the same server (/promo, but not //host or /\host). Empty list (default) allows any
+// host.
+func WithAllowedTargets(hosts ...string) Option {
+	return func(eng *engine) {
+		eng.targetHosts = hosts
+	}
+}
+
+// WithAllowedSchemes restricts schemes (ex: https) of redirect targets the same way as WithAllowedTargets. Empty list
+// (default) allows any scheme.
+func WithAllowedSchemes(schemes ...string) Option {
+	return func(eng *engine) {
+		eng.allowSchemes = nil
+		for _, scheme := range schemes {
+			eng.allowSchemes = append(eng.allowSchemes, strings.ToLower(scheme))
+		}
+	}
+}
+
+// WithDefaultURL sets template (the same as rule location) of redirect for missed requests. Template gets request
+// context, ex: https://old.example{{.URL.Path}} forwards unknown paths to legacy shortener. By default missed requests
+// get not found page or error.
+func WithDefaultURL(template string) Option {
+	return func(eng *engine) {
+		eng.defaultText = template
+	}
+}
+
+// WithTrackingParam sets query parameter added to targets for regular users (see WithTrackingParams for values).
+func WithTrackingParam(name string) Option {
+	return func(eng *engine) {
+		eng.urlParameter = name
+	}
+}
+
+// WithRobots sets substrings of User-Agent of robots: visits of robots are counted separately and get no tracking
+// parameters. Empty agents are ignored.
+func WithRobots(agents ...string) Option {
+	return func(eng *engine) {
+		eng.robots = agents
+	}
+}
+
+// WithStatus sets default redirect status: one of 301 (default), 302, 307 or 308. Rules may override it.
+func WithStatus(status int) Option {
+	return func(eng *engine) {
+		eng.status = status
+	}
+}
+
+// NewEngine creates engine based on provided storage and sink. Rules are not loaded until Reload.
+func NewEngine(storage Storage, sink StatWriter, options ...Option) (Engine, error) {
 	if storage == nil {
 		panic("storage is nil")
 	}
@@ -29,110 +516,826 @@ func DefaultEngine(storage Storage, sink StatWriter, defaultUrl string, urlParam
 		panic("stats sink is nil")
 	}
 
-	return &engine{
-		storage:      storage,
-		stat:         sink,
-		defaultUrl:   defaultUrl,
-		urlParameter: urlParameter,
-		robots:       strings.Split(robots, "|"),
+	eng := &engine{
+		storage:        storage,
+		stat:           sink,
+		status:         http.StatusMovedPermanently,
+		stickyKey:      DefaultStickyKey,
+		metricsLimit:   DefaultMetricsServiceLimit,
+		logger:         slog.Default(),
+		webhookQueue:   DefaultWebhookQueue,
+		traces:         otel.GetTracerProvider(),
+		propagator:     propagation.TraceContext{},
+		robotsTxt:      DefaultRobotsTxt,
+		notFoundStatus: http.StatusNotFound,
+		dryRunParam:    DefaultDryRunParam,
+		maxRules:       DefaultMaxRules,
+		maxTemplate:    DefaultMaxTemplateSize,
+	}
+	eng.current.Store(&ruleSet{})
+	eng.counter, _ = sink.(StatReader)
+	for _, opt := range options {
+		opt(eng)
 	}
+	if eng.status == 0 {
+		eng.status = http.StatusMovedPermanently
+	}
+	if !isRedirectStatus(eng.status) {
+		return nil, fmt.Errorf("engine: unsupported redirect status %d", eng.status)
+	}
+	eng.requestLog = eng.logger
+	if eng.sampleLimit > 0 {
+		if eng.samplePeriod <= 0 {
+			return nil, fmt.Errorf("engine: log sample period should be positive, got %v", eng.samplePeriod)
+		}
+		eng.requestLog = slog.New(newSamplingHandler(eng.logger.Handler(), eng.sampleLimit, eng.samplePeriod))
+	}
+	if eng.defaultText != "" {
+		location, err := parseLocation(eng.defaultText)
+		if err != nil {
+			return nil, fmt.Errorf("engine: parse default URL: %w", err)
+		}
+		eng.defaultUrl = newTarget(location, 1)
+	}
+	if len(eng.targetHosts) > 0 {
+		hosts, err := compileHosts(eng.targetHosts)
+		if err != nil {
+			return nil, fmt.Errorf("engine: allowed targets: %w", err)
+		}
+		eng.allowHosts = hosts
+	}
+	if eng.notFoundText != "" {
+		page, err := parseNotFoundPage(eng.notFoundText)
+		if err != nil {
+			return nil, fmt.Errorf("engine: parse not found page: %w", err)
+		}
+		eng.notFoundPage = page
+	}
+	if eng.geoFile != "" {
+		geo, err := openGeoIP(eng.geoFile, DefaultGeoCache)
+		if err != nil {
+			return nil, fmt.Errorf("engine: open GeoIP database: %w", err)
+		}
+		eng.geo = geo
+	}
+	eng.metrics = newMetrics(eng.metricsLimit, eng.metricsByTag)
+	eng.hooks = newWebhook(eng.webhookQueue, DefaultWebhookRetries, eng.requestLog)
+	eng.tracer = eng.traces.Tracer(tracerName)
+	if eng.rateLimit > 0 {
+		eng.limiter = newLimiter(eng.rateLimit, eng.rateBurst, eng.rateClients)
+	}
+	return eng, nil
+}
+
+// Create default engine based on provided storage and sink. Zero defaultStatus means 301 (Moved Permanently),
+// otherwise it should be one of redirect codes: 301, 302, 307 or 308. The defaultUrl is template (the same as rule
+// location) of redirect for missed requests. Robots are separated by |.
+//
+// Deprecated: use NewEngine with WithDefaultURL, WithTrackingParam, WithRobots and WithStatus.
+func DefaultEngine(storage Storage, sink StatWriter, defaultUrl string, urlParameter string, robots string, defaultStatus int, options ...Option) (Engine, error) {
+	return NewEngine(storage, sink, append([]Option{
+		WithDefaultURL(defaultUrl),
+		WithTrackingParam(urlParameter),
+		WithRobots(strings.Split(robots, "|")...),
+		WithStatus(defaultStatus),
+	}, options...)...)
 }
 
 func (eng *engine) ServeHTTP(wr http.ResponseWriter, rq *http.Request) {
 	defer rq.Body.Close()
+	started := time.Now()
+	defer func() {
+		eng.metrics.duration.Observe(time.Since(started).Seconds())
+	}()
 
-	service := strings.Trim(rq.URL.Path, "/")
+	service := eng.servicePath(rq)
 
-	// try to find redirect rule
-	eng.lock.RLock()
-	tpl, ok := eng.rules[service]
-	eng.lock.RUnlock()
+	ctx := eng.propagator.Extract(rq.Context(), propagation.HeaderCarrier(rq.Header))
+	ctx, span := eng.tracer.Start(ctx, "redirect", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.request.method", rq.Method),
+		attribute.String("redirect.service", service),
+	))
+	defer span.End()
+	rq = rq.WithContext(ctx)
 
-	if !ok {
-		if eng.defaultUrl != "" {
-			eng.Redirect(eng.defaultUrl, wr, rq)
-		} else {
-			http.NotFound(wr, rq)
+	// limit requests of client
+	if eng.limiter != nil {
+		if ok, wait := eng.limiter.allow(eng.clientIP(rq), started); !ok {
+			span.SetAttributes(attribute.Bool("redirect.limited", true))
+			wr.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			eng.sendError(wr, rq, http.StatusTooManyRequests)
+			return
 		}
+	}
 
+	if eng.robotsTxt != "" && rq.URL.Path == "/robots.txt" {
+		wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(wr, eng.robotsTxt)
 		return
 	}
 
-	// notify stat counter
-	eng.stat.Touch(service)
+	// find redirect rule and render target
+	d := eng.decide(rq)
+	if d.rt == nil && !d.missed {
+		eng.sendError(wr, rq, d.status) // outside of path prefix
+		return
+	}
+	span.SetAttributes(attribute.Bool("redirect.matched", d.rt != nil))
+	if d.rt != nil {
+		span.SetAttributes(attribute.String("redirect.rule", d.rt.rule.URL))
+	}
 
-	// render redirect template
-	urlData := &bytes.Buffer{}
-	err := tpl.Execute(urlData, rq)
+	// notify stat counter, previews of target (HEAD and dry-run) are not visits
+	isBot := !eng.IsRegularUser(rq)
+	preview := rq.Method == http.MethodHead || eng.dryRun(rq)
+	if d.admitted && !preview {
+		eng.stat.Touch(d.rt.rule.URL, isBot)
+		eng.metrics.hit(d.rt.rule)
+	}
 
-	if err != nil {
-		log.Println("engine: failed execute template for service", service, ":", err)
-		http.Error(wr, err.Error(), http.StatusInternalServerError)
+	if d.missed {
+		eng.notFound(wr, rq, d)
+		return
+	}
+	if !d.redirects() {
+		eng.sendError(wr, rq, d.status)
 		return
 	}
+	rt, env, url := d.rt, d.env, d.target
 
-	url := strings.TrimSpace(urlData.String())
+	eng.setHeaders(rt, env, wr.Header())
 
 	// We send TARGET in Location header on HEAD request with 200 OK status
-	if rq.Method == "HEAD" {
+	if rq.Method == http.MethodHead {
+		wr.Header().Add("Location", url)
+		wr.Header().Set(StatusHeader, strconv.Itoa(d.status))
+		wr.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// the same for dry-run requests of any method, target is also sent as body
+	if eng.dryRun(rq) {
 		wr.Header().Add("Location", url)
+		wr.Header().Set(StatusHeader, strconv.Itoa(d.status))
+		wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		wr.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(wr, url+"\n")
 		return
 	}
 
-	eng.Redirect(url, wr, rq)
+	span.SetAttributes(attribute.String("redirect.target", url), attribute.Int("http.response.status_code", d.status))
+	eng.requestLog.Debug("redirect", "service", service, "rule", rt.rule.URL, "target", url, "status", d.status, "bot", isBot)
+	eng.redirect(rt.rule, url, d.status, wr, rq)
+
+	if hook := eng.webhook(rt); hook != "" {
+		eng.hooks.notify(&WebhookEvent{
+			Service:   service,
+			Target:    url,
+			UserAgent: rq.UserAgent(),
+			Timestamp: started,
+			IP:        env.RemoteIP,
+			url:       hook,
+		})
+	}
 }
 
-func (eng *engine) Reload() error {
-	rules, err := eng.storage.All()
+// Reload rules from storage and log outcome. Previous rules are kept if reload failed.
+func (eng *engine) Reload(ctx context.Context) error {
+	eng.reloading.Lock()
+	defer eng.reloading.Unlock()
+	err := eng.reload(ctx)
+	eng.lock.Lock()
+	if err != nil {
+		eng.failures++
+		eng.reloadErr = err
+		eng.failedAt = time.Now()
+	} else {
+		eng.loaded = true
+		eng.failures = 0
+		eng.reloadErr = nil
+	}
+	eng.lock.Unlock()
+	if err != nil {
+		eng.metrics.failures.Inc()
+		eng.logger.Error("failed reload rules, previous rules kept", "err", err)
+		return err
+	}
+	set := eng.current.Load()
+	exact, wildcards, patterns := set.counts()
+	eng.metrics.rules.Set(float64(exact + wildcards + patterns))
+	eng.metrics.reloaded.Set(float64(set.loadedAt.UnixNano()) / 1e9)
+	eng.logger.Info("rules reloaded", "exact", exact, "wildcards", wildcards, "patterns", patterns, "skipped", len(set.invalid))
+	return nil
+}
+
+// Ready checks that rules are loaded, less than ReadyFailures reloads in row failed and storage is reachable (if
+// storage supports Ping).
+func (eng *engine) Ready() error {
+	eng.lock.RLock()
+	loaded, failures, reloadErr := eng.loaded, eng.failures, eng.reloadErr
+	eng.lock.RUnlock()
+	if !loaded {
+		return fmt.Errorf("engine: rules are not loaded yet")
+	}
+	if failures >= ReadyFailures {
+		return fmt.Errorf("engine: %d reloads in row failed: %w", failures, reloadErr)
+	}
+	if pinger, ok := eng.storage.(interface{ Ping() error }); ok {
+		if err := pinger.Ping(); err != nil {
+			return fmt.Errorf("engine: storage is not reachable: %w", err)
+		}
+	}
+	return nil
+}
+
+func (eng *engine) reload(ctx context.Context) error {
+	var robots []string
+	if eng.robotsFile != "" {
+		list, err := readRobots(eng.robotsFile)
+		if err != nil {
+			return fmt.Errorf("engine: read robots file: %w", err)
+		}
+		robots = list
+	}
+	rules, err := eng.storage.All(ctx)
 	if err != nil {
 		return fmt.Errorf("engine: read rules from storage: %w", err)
 	}
-	var swap = make(map[string]*template.Template)
+	if eng.maxRules > 0 && len(rules) > eng.maxRules {
+		return fmt.Errorf("engine: too many rules: %d, limit is %d", len(rules), eng.maxRules)
+	}
+	var swap = make(map[string]*route)
+	var fallbacks []*route
+	var invalid map[string]error
 	for _, rule := range rules {
-		t, err := template.New("").Parse(rule.LocationTemplate)
+		if rule.Disabled {
+			continue
+		}
+		rt, err := eng.compile(rule)
+		if err != nil && eng.strict {
+			return err
+		}
 		if err != nil {
-			return fmt.Errorf("engine: parse rule for url %v: %w", rule.URL, err)
+			eng.logger.Warn("invalid rule skipped", "rule", rule.URL, "err", err)
+			if invalid == nil {
+				invalid = make(map[string]error)
+			}
+			invalid[rule.URL] = err
+			continue
+		}
+		if rule.Regex {
+			fallbacks = append(fallbacks, rt)
+		} else if strings.HasSuffix(rule.URL, Wildcard) {
+			rt.prefix = eng.ruleKey(strings.TrimSuffix(rule.URL, Wildcard))
+			fallbacks = append(fallbacks, rt)
+		} else {
+			key := eng.ruleKey(rule.URL)
+			if other, exists := swap[key]; exists {
+				return fmt.Errorf("engine: rules for url %v and %v are conflicting", other.rule.URL, rule.URL)
+			}
+			swap[key] = rt
 		}
-		swap[rule.URL] = t
 	}
-	eng.lock.Lock()
-	eng.rules = swap
-	eng.lock.Unlock()
+	sort.SliceStable(fallbacks, func(i, j int) bool {
+		a, b := fallbacks[i], fallbacks[j]
+		if a.rule.Priority != b.rule.Priority {
+			return a.rule.Priority > b.rule.Priority
+		}
+		if (a.pattern == nil) != (b.pattern == nil) {
+			return a.pattern == nil // wildcards first
+		}
+		if len(a.prefix) != len(b.prefix) {
+			return len(a.prefix) > len(b.prefix) // the most specific prefix first
+		}
+		return a.rule.URL < b.rule.URL
+	})
+	if err := eng.findLoop(swap, fallbacks); err != nil {
+		return err
+	}
+	eng.current.Store(&ruleSet{rules: swap, fallbacks: fallbacks, robots: robots, loadedAt: time.Now(), invalid: invalid})
 	return nil
 }
 
-func (eng *engine) Redirect(url string, wr http.ResponseWriter, rq *http.Request) {
-	if eng.IsRegularUser(rq) {
-		url = eng.ProcessRegularUserUrl(url)
+// compile rule with respect to limits of engine.
+func (eng *engine) compile(rule *Rule) (*route, error) {
+	if size := templateSize(rule); eng.maxTemplate > 0 && size > eng.maxTemplate {
+		return nil, fmt.Errorf("engine: rule for url %v has template of %d bytes, limit is %d", rule.URL, size, eng.maxTemplate)
+	}
+	if rule.MaxHits > 0 && eng.counter == nil {
+		return nil, fmt.Errorf("engine: rule for url %v has hits limit but stats sink can not be read", rule.URL)
+	}
+	rt, err := compileRule(rule)
+	if err != nil {
+		return nil, fmt.Errorf("engine: rule for url %v: %w", rule.URL, err)
+	}
+	return rt, nil
+}
+
+// size of the largest template of rule.
+func templateSize(rule *Rule) int {
+	size := max(len(rule.LocationTemplate), len(rule.Fallback))
+	for _, target := range rule.Targets {
+		size = max(size, len(target.Template))
+	}
+	for _, location := range rule.Countries {
+		size = max(size, len(location))
+	}
+	for _, location := range rule.Languages {
+		size = max(size, len(location))
+	}
+	for _, scheduled := range rule.Schedule {
+		size = max(size, len(scheduled.Template))
+	}
+	for _, value := range rule.Headers {
+		size = max(size, len(value))
+	}
+	return size
+}
+
+func (eng *engine) Close() error {
+	eng.hooks.close()
+	var errs []string
+	if closer, ok := eng.stat.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, "close stats: "+err.Error())
+		}
+	}
+	if eng.geo != nil {
+		if err := eng.geo.close(); err != nil {
+			errs = append(errs, "close GeoIP: "+err.Error())
+		}
+	}
+	if closer, ok := eng.storage.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, "close storage: "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("engine: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (eng *engine) Metrics() http.Handler {
+	return eng.metrics.handler()
+}
+
+// statusNotMatched is pseudo status of request handled as if no rule matched: default URL or not found page.
+const statusNotMatched = -1
+
+// admit checks that matched rule serves request now: schedule, referer and limit of hits. Returns 0 if request
+// allowed, otherwise status of response or statusNotMatched.
+func (eng *engine) admit(rt *route, service string, rq *http.Request) int {
+	span := trace.SpanFromContext(rq.Context())
+	if now := time.Now(); !rt.active(now) {
+		if eng.expired != 0 && rt.expired(now) {
+			return eng.expired
+		}
+		return statusNotMatched
+	}
+
+	if !rt.refererAllowed(rq.Referer()) {
+		span.SetAttributes(attribute.Bool("redirect.blocked", true))
+		if eng.refererNotFound {
+			return statusNotMatched
+		}
+		return http.StatusForbidden
+	}
+
+	if rt.rule.MaxHits > 0 {
+		hits, err := eng.counter.Count(rt.rule.URL)
+		if err != nil {
+			eng.requestLog.Error("failed get hits", "service", service, "rule", rt.rule.URL, "err", err)
+			eng.metrics.errors.Inc()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "get hits")
+			return http.StatusInternalServerError
+		}
+		if hits >= rt.rule.MaxHits {
+			return http.StatusGone
+		}
+	}
+	return 0
+}
+
+// target of matched rule: location chosen for visitor or fallback of rule if location failed.
+func (eng *engine) target(rt *route, env *TemplateContext, service string, rq *http.Request) (string, error) {
+	span := trace.SpanFromContext(rq.Context())
+	env.bind(rq, service, eng.clientIP(rq), eng.geo)
+	url, err := rt.choose(env, eng.sticky(rq), time.Now()).render(env)
+
+	if err != nil && rt.fallback != nil {
+		eng.requestLog.Warn("failed execute template, fallback used", "service", service, "rule", rt.rule.URL, "err", err)
+		eng.metrics.errors.Inc()
+		span.RecordError(err)
+		url, err = rt.fallback.render(env)
+	}
+
+	if err != nil {
+		eng.requestLog.Error("failed execute template", "service", service, "rule", rt.rule.URL, "err", err)
+		eng.metrics.errors.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "execute template")
+	}
+	return url, err
+}
+
+// handle request without matched rule: redirect to rendered default URL (if set) or not found page.
+func (eng *engine) notFound(wr http.ResponseWriter, rq *http.Request, d *decision) {
+	eng.metrics.notFound.Inc()
+	switch {
+	case eng.defaultUrl == nil:
+		eng.sendNotFoundPage(wr, rq)
+	case d.redirects():
+		eng.Redirect(d.target, d.status, wr, rq)
+	default:
+		eng.sendError(wr, rq, d.status)
+	}
+}
+
+// render default URL for request without matched rule.
+func (eng *engine) defaultTarget(rq *http.Request) (string, error) {
+	service := eng.servicePath(rq)
+	env := &TemplateContext{}
+	env.bind(rq, service, eng.clientIP(rq), eng.geo)
+	url, err := eng.defaultUrl.render(env)
+	if err != nil {
+		eng.requestLog.Error("failed execute default URL template", "service", service, "err", err)
+		eng.metrics.errors.Inc()
+	}
+	return url, err
+}
+
+// find rule for service: exact match first, then wildcard and regex rules by priority. Rules with the same priority
+// are checked in order: the longest wildcard prefix, then regex rules.
+// Returns template environment with captured path parts (request is not set).
+func (eng *engine) match(service string) (*route, *TemplateContext, bool) {
+	set := eng.current.Load()
+	return eng.lookup(set.rules, set.fallbacks, service)
+}
+
+// lookup service in provided rules: exact rules first, then fallbacks in order.
+func (eng *engine) lookup(rules map[string]*route, fallbacks []*route, service string) (*route, *TemplateContext, bool) {
+	if rt, ok := rules[eng.key(service)]; ok {
+		return rt, &TemplateContext{}, true
+	}
+	for _, rt := range fallbacks {
+		if rt.pattern == nil {
+			if eng.hasPrefix(service, rt.prefix) {
+				return rt, &TemplateContext{Tail: strings.TrimPrefix(service[len(rt.prefix):], "/")}, true
+			}
+			if eng.key(service) == strings.TrimSuffix(rt.prefix, "/") {
+				return rt, &TemplateContext{}, true
+			}
+			continue
+		}
+		groups := rt.pattern.FindStringSubmatch(service)
+		if groups == nil {
+			continue
+		}
+		var params = make(map[string]string)
+		for i, name := range rt.pattern.SubexpNames() {
+			if name != "" {
+				params[name] = groups[i]
+			}
+		}
+		return rt, &TemplateContext{Params: params}, true
+	}
+	return nil, nil, false
+}
+
+// Cache-Control of rule or engine default.
+func (eng *engine) cacheHeader(rule *Rule) string {
+	if rule != nil && rule.CacheControl != "" {
+		return rule.CacheControl
+	}
+	return eng.cacheControl
+}
+
+// set response headers of rule. Headers with failed templates are skipped.
+func (eng *engine) setHeaders(rt *route, env *TemplateContext, header http.Header) {
+	for name, t := range rt.headers {
+		value, err := t.render(env)
+		if err != nil {
+			eng.requestLog.Error("failed execute header template", "rule", rt.rule.URL, "header", name, "err", err)
+			eng.metrics.errors.Inc()
+			continue
+		}
+		header.Set(name, value)
+	}
+}
+
+// setCacheHeaders sets Cache-Control and Expires (if max-age defined) headers.
+func setCacheHeaders(header http.Header, cacheControl string, now time.Time) {
+	header.Set("Cache-Control", cacheControl)
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			header.Set("Expires", now.Add(time.Duration(seconds)*time.Second).UTC().Format(http.TimeFormat))
+		}
+		return
+	}
+}
+
+// webhook URL of rule or engine default.
+func (eng *engine) webhook(rt *route) string {
+	if rt.rule.Webhook != "" {
+		return rt.rule.Webhook
+	}
+	return eng.webhookURL
+}
+
+// service path of request used for matching: decoded (unless raw path enabled) request path without leading and
+// trailing (unless it's significant) slashes.
+func (eng *engine) servicePath(rq *http.Request) string {
+	return eng.normalize(rq.URL)
+}
+
+// normalize path of URL to service name.
+func (eng *engine) normalize(u *url.URL) string {
+	path := u.Path
+	if eng.rawPath {
+		path = u.EscapedPath()
+	}
+	if eng.mounted(u) {
+		path = strings.TrimPrefix(path, eng.pathPrefix)
+	}
+	if eng.collapse {
+		for strings.Contains(path, "//") {
+			path = strings.ReplaceAll(path, "//", "/")
+		}
+	}
+	path = norm.NFC.String(path)
+	if eng.trailingSlash {
+		return strings.TrimLeft(path, "/")
+	}
+	return strings.Trim(path, "/")
+}
+
+// mounted reports whether path of URL is under path prefix of engine.
+func (eng *engine) mounted(u *url.URL) bool {
+	if eng.pathPrefix == "" {
+		return true
+	}
+	return u.Path == eng.pathPrefix || strings.HasPrefix(u.Path, eng.pathPrefix+"/")
+}
+
+// check that request is dry-run: query parameter is set to true value.
+func (eng *engine) dryRun(rq *http.Request) bool {
+	if eng.dryRunParam == "" {
+		return false
+	}
+	value, _ := strconv.ParseBool(rq.URL.Query().Get(eng.dryRunParam))
+	return value
+}
+
+// sticky value of visitor from query or cookie.
+func (eng *engine) sticky(rq *http.Request) string {
+	if value := rq.URL.Query().Get(eng.stickyKey); value != "" {
+		return value
+	}
+	if cookie, err := rq.Cookie(eng.stickyKey); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// lookup key of rule URL: percent-encoding is decoded (unless raw path enabled) and Unicode is normalized (NFC) the
+// same way as request path, so both forms of rule URL (caf%C3%A9 and café) match.
+func (eng *engine) ruleKey(ruleURL string) string {
+	if !eng.rawPath {
+		if decoded, err := url.PathUnescape(ruleURL); err == nil {
+			ruleURL = decoded
+		}
+	}
+	return eng.key(norm.NFC.String(ruleURL))
+}
+
+// lookup key of path: lowered in case-insensitive mode.
+func (eng *engine) key(path string) string {
+	if eng.ignoreCase {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+// check path prefix (prefix should be converted by key).
+func (eng *engine) hasPrefix(path string, prefix string) bool {
+	if eng.ignoreCase {
+		return len(path) >= len(prefix) && strings.EqualFold(path[:len(prefix)], prefix)
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+// Redirect to url with forwarded query and tracking parameters of engine (the same as default URL redirect).
+func (eng *engine) Redirect(url string, status int, wr http.ResponseWriter, rq *http.Request) {
+	eng.redirect(nil, url, status, wr, rq)
+}
+
+// redirect to url with respect to tracking settings of rule (if any).
+func (eng *engine) redirect(rule *Rule, url string, status int, wr http.ResponseWriter, rq *http.Request) {
+	url = eng.location(rule, url, rq)
+
+	if cacheControl := eng.cacheHeader(rule); cacheControl != "" {
+		setCacheHeaders(wr.Header(), cacheControl, time.Now())
+	}
+
+	if eng.refresh(rule, rq) && refreshable(url) {
+		eng.sendRefresh(wr, url)
+		return
 	}
 
 	wr.Header().Add("Content-Length", "0")
-	http.Redirect(wr, rq, url, http.StatusMovedPermanently)
+	http.Redirect(wr, rq, url, status)
+}
+
+// location of redirect: target with forwarded query and tracking parameters.
+func (eng *engine) location(rule *Rule, url string, rq *http.Request) string {
+	url = asciiHost(url)
+
+	if eng.forwardQuery {
+		url = forwardQuery(url, eng.forwardedQuery(rq))
+	}
+
+	if eng.tracking(rule, rq) {
+		url = eng.addTracking(rule, url)
+	}
+	return url
+}
+
+// query of request forwarded to target: without control parameters of engine (dry-run and refresh).
+func (eng *engine) forwardedQuery(rq *http.Request) url.Values {
+	query := rq.URL.Query()
+	for _, param := range []string{eng.dryRunParam, eng.refreshParam} {
+		if param != "" {
+			query.Del(param)
+		}
+	}
+	return query
 }
 
+// IsRegularUser returns false if User-Agent matches robots (and not listed in regular users).
 func (eng *engine) IsRegularUser(rq *http.Request) bool {
 	userAgent := strings.ToLower(rq.UserAgent())
 
+	for _, agent := range eng.regularUsers {
+		if strings.Contains(userAgent, agent) {
+			return true
+		}
+	}
+
 	for _, robot := range eng.robots {
 		if robot != "" && strings.Contains(userAgent, robot) {
 			return false
 		}
 	}
 
+	for _, robot := range eng.current.Load().robots {
+		if strings.Contains(userAgent, robot) {
+			return false
+		}
+	}
+
 	return true
 }
 
+// tracking parameter is added for regular users unless rule overrides it.
+func (eng *engine) tracking(rule *Rule, rq *http.Request) bool {
+	if rule != nil && rule.NoTracking {
+		return false
+	}
+	if !eng.consented(rq) {
+		return false
+	}
+	if rule != nil && rule.TreatBotsAsUsers {
+		return true
+	}
+	return eng.IsRegularUser(rq)
+}
+
+// consented checks that client allowed tracking by consent cookie (if configured).
+func (eng *engine) consented(rq *http.Request) bool {
+	if eng.consentCookie == "" {
+		return true
+	}
+	cookie, err := rq.Cookie(eng.consentCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return eng.consentValue == "" || cookie.Value == eng.consentValue
+}
+
+// readRobots reads lowered user agent substrings from file: one per line, empty lines and lines started by # ignored.
+func readRobots(fileName string) ([]string, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var ans []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ans = append(ans, strings.ToLower(line))
+	}
+	return ans, nil
+}
+
 func (eng *engine) ProcessRegularUserUrl(url string) string {
-	if eng.urlParameter == "" {
-		return url
+	return eng.addTracking(nil, url)
+}
+
+// addTracking adds urlParameter (as-is) and tracking parameters of engine (with source parameter) merged with
+// parameters of rule (if any).
+func (eng *engine) addTracking(rule *Rule, target string) string {
+	params := eng.trackingParams
+	if rule != nil && (len(rule.TrackingParams) > 0 || eng.sourceParam != "") {
+		params = make(url.Values, len(eng.trackingParams)+len(rule.TrackingParams)+1)
+		for key, values := range eng.trackingParams {
+			params[key] = values
+		}
+		if eng.sourceParam != "" {
+			params.Set(eng.sourceParam, rule.URL)
+		}
+		for key, value := range rule.TrackingParams {
+			if value == "" {
+				params.Del(key)
+			} else {
+				params.Set(key, value)
+			}
+		}
+	}
+	if eng.urlParameter != "" {
+		target = appendQuery(target, eng.urlParameter)
+	}
+	if len(params) > 0 {
+		target = appendQuery(target, params.Encode())
 	}
+	return target
+}
 
-	if strings.Contains(url, "?") {
-		url = url + "&" + eng.urlParameter
+// appendQuery adds raw query (ex: a=b) to query component of target, so fragment stays at the end.
+func appendQuery(target string, query string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		// keep invalid targets as-is except query placement
+		base, fragment, hasFragment := strings.Cut(target, "#")
+		if strings.Contains(base, "?") {
+			base += "&" + query
+		} else {
+			base += "?" + query
+		}
+		if hasFragment {
+			base += "#" + fragment
+		}
+		return base
+	}
+	if u.RawQuery == "" {
+		u.RawQuery = query
 	} else {
-		url = url + "?" + eng.urlParameter
+		u.RawQuery += "&" + query
 	}
+	u.ForceQuery = false
+	return u.String()
+}
 
-	return url
+// forwardQuery adds to target parameters from incoming query which are not yet defined in target.
+// Target left as-is if it's not valid URL.
+func forwardQuery(target string, incoming url.Values) string {
+	if len(incoming) == 0 {
+		return target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	defined := u.Query()
+	var extra = make(url.Values)
+	for key, values := range incoming {
+		if _, exists := defined[key]; !exists {
+			extra[key] = values
+		}
+	}
+	if len(extra) == 0 {
+		return target
+	}
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += extra.Encode()
+	return u.String()
+}
+
+// isRedirectStatus checks that code is one of supported redirect statuses.
+func isRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
 }
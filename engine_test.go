@@ -0,0 +1,207 @@
+package redirect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// testRules creates engine with in-memory storage and stats for full rules.
+func testRules(t testing.TB, rules []Rule, options ...Option) Engine {
+	t.Helper()
+	ctx := context.Background()
+	storage := &JSONStorage{}
+	for _, rule := range rules {
+		if err := storage.Put(ctx, rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eng, err := NewEngine(storage, InMemoryStats(), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = eng.Close() })
+	return eng
+}
+
+func TestServeHTTPPreservesMethod(t *testing.T) {
+	cases := []struct {
+		service  string
+		location string
+		status   int
+	}{
+		{"api", "https://api.example.com/v2/hook", http.StatusPermanentRedirect},
+		{"tmp", "https://api.example.com/tmp", http.StatusTemporaryRedirect},
+	}
+	var rules []Rule
+	for _, c := range cases {
+		rules = append(rules, Rule{URL: c.service, LocationTemplate: c.location, Status: c.status})
+	}
+	eng := testRules(t, rules)
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPost, "/"+c.service, strings.NewReader(`{"event":"push"}`))
+		rq.Header.Set("Content-Type", "application/json")
+		eng.ServeHTTP(rec, rq)
+		if rec.Code != c.status {
+			t.Errorf("%s: status %d, want %d", c.service, rec.Code, c.status)
+		}
+		if location := rec.Header().Get("Location"); location != c.location {
+			t.Errorf("%s: location %q, want %q", c.service, location, c.location)
+		}
+	}
+}
+
+func TestTemplateFuncsConcurrent(t *testing.T) {
+	eng := testRules(t, []Rule{{URL: "promo", LocationTemplate: `https://example.com/?ref={{queryGet "ref"}}&ua={{header "X-Client"}}`}})
+
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		go func() {
+			for i := 0; i < 200; i++ {
+				ref := fmt.Sprintf("g%d-%d", g, i)
+				rq := httptest.NewRequest(http.MethodGet, "/promo?ref="+ref, nil)
+				rq.Header.Set("X-Client", ref)
+				rec := httptest.NewRecorder()
+				eng.ServeHTTP(rec, rq)
+				if want := "https://example.com/?ref=" + ref + "&ua=" + ref; rec.Header().Get("Location") != want {
+					errs <- fmt.Errorf("location %q, want %q", rec.Header().Get("Location"), want)
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for g := 0; g < 8; g++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestAppendQuery(t *testing.T) {
+	cases := []struct {
+		target string
+		want   string
+	}{
+		{"https://example.com/p", "https://example.com/p?utm=1"},
+		{"https://example.com/p?a=1", "https://example.com/p?a=1&utm=1"},
+		{"https://example.com/p?a=1#top", "https://example.com/p?a=1&utm=1#top"},
+		{"https://example.com/p#frag?x=1", "https://example.com/p?utm=1#frag?x=1"},
+		{"https://example.com/p?q=a%26b#x%23y", "https://example.com/p?q=a%26b&utm=1#x%23y"},
+		{"https://example.com/%zz#top", "https://example.com/%zz?utm=1#top"}, // invalid URL
+	}
+	for _, c := range cases {
+		if got := appendQuery(c.target, "utm=1"); got != c.want {
+			t.Errorf("appendQuery(%q) = %q, want %q", c.target, got, c.want)
+		}
+	}
+}
+
+func TestForwardQuery(t *testing.T) {
+	cases := []struct {
+		target   string
+		incoming string
+		want     string
+	}{
+		{"https://example.com/p", "", "https://example.com/p"},
+		{"https://example.com/p?a=1", "a=2&b=3", "https://example.com/p?a=1&b=3"},
+		{"https://example.com/p#top", "b=3", "https://example.com/p?b=3#top"},
+		{"https://example.com/p?a=1#top", "c=x%26y&d=%23", "https://example.com/p?a=1&c=x%26y&d=%23#top"},
+	}
+	for _, c := range cases {
+		incoming, err := url.ParseQuery(c.incoming)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := forwardQuery(c.target, incoming); got != c.want {
+			t.Errorf("forwardQuery(%q, %q) = %q, want %q", c.target, c.incoming, got, c.want)
+		}
+	}
+}
+
+func TestServeHTTPForwardQueryAndTracking(t *testing.T) {
+	eng := testRules(t, []Rule{{URL: "promo", LocationTemplate: "https://example.com/land?a=1#offer"}},
+		WithForwardQuery(), WithTrackingParam("ref=short"))
+
+	rec := httptest.NewRecorder()
+	eng.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/promo?b=x%26y&a=2", nil))
+	if location, want := rec.Header().Get("Location"), "https://example.com/land?a=1&b=x%26y&ref=short#offer"; location != want {
+		t.Errorf("location %q, want %q", location, want)
+	}
+}
+
+func BenchmarkServeHTTPStatic(b *testing.B) {
+	eng := testRules(b, []Rule{{URL: "promo", LocationTemplate: "https://example.com/landing"}})
+	rq := httptest.NewRequest(http.MethodGet, "/promo", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		eng.ServeHTTP(httptest.NewRecorder(), rq)
+	}
+}
+
+func BenchmarkServeHTTPTemplate(b *testing.B) {
+	eng := testRules(b, []Rule{{URL: "promo", LocationTemplate: `https://example.com/{{.Query.c}}?ref={{queryGet "ref"}}`}})
+	rq := httptest.NewRequest(http.MethodGet, "/promo?c=mail&ref=news", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		eng.ServeHTTP(httptest.NewRecorder(), rq)
+	}
+}
+
+func TestPreviewNotCounted(t *testing.T) {
+	ctx := context.Background()
+	storage := &JSONStorage{}
+	if err := storage.Put(ctx, Rule{URL: "promo", LocationTemplate: "https://example.com/"}); err != nil {
+		t.Fatal(err)
+	}
+	stats := InMemoryStats()
+	eng, err := NewEngine(storage, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eng.Close()
+	if err := eng.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rq := range []*http.Request{
+		httptest.NewRequest(http.MethodHead, "/promo", nil),
+		httptest.NewRequest(http.MethodGet, "/promo?"+DefaultDryRunParam+"=1", nil),
+	} {
+		rec := httptest.NewRecorder()
+		eng.ServeHTTP(rec, rq)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d, want %d", rq.Method, rq.URL, rec.Code, http.StatusOK)
+		}
+	}
+	if count, _ := stats.Count("promo"); count != 0 {
+		t.Fatalf("previews counted: %d hits", count)
+	}
+	eng.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/promo", nil))
+	if count, _ := stats.Count("promo"); count != 1 {
+		t.Fatalf("redirect counted as %d hits, want 1", count)
+	}
+}
+
+func TestDryRunForwardQuery(t *testing.T) {
+	eng := testRules(t, []Rule{{URL: "promo", LocationTemplate: "https://example.com/land?a=1"}}, WithForwardQuery())
+
+	target, matched, status, err := eng.Resolve(httptest.NewRequest(http.MethodGet, "/promo?b=2&"+DefaultDryRunParam+"=1", nil))
+	if err != nil || !matched || status != http.StatusMovedPermanently {
+		t.Fatalf("resolve: matched %v, status %d, err %v", matched, status, err)
+	}
+	if want := "https://example.com/land?a=1&b=2"; target != want {
+		t.Errorf("target %q, want %q", target, want)
+	}
+}
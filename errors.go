@@ -0,0 +1,49 @@
+package redirect
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// error response of redirect server for JSON clients.
+type errorResponse struct {
+	Error   string `json:"error"`
+	Service string `json:"service"`
+}
+
+// sendError responds by generic text of status without details (they should be logged by caller): JSON if client
+// accepts it, plain text otherwise.
+func (eng *engine) sendError(wr http.ResponseWriter, rq *http.Request, status int) {
+	if !acceptsJSON(rq) {
+		if status == http.StatusNotFound {
+			http.NotFound(wr, rq)
+		} else {
+			http.Error(wr, http.StatusText(status), status)
+		}
+		return
+	}
+	data, err := json.Marshal(&errorResponse{Error: http.StatusText(status), Service: eng.servicePath(rq)})
+	if err != nil {
+		http.Error(wr, http.StatusText(status), status)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json; charset=utf-8")
+	wr.Header().Set("X-Content-Type-Options", "nosniff")
+	wr.WriteHeader(status)
+	_, _ = wr.Write(append(data, '\n'))
+}
+
+// acceptsJSON checks that Accept header of request contains application/json.
+func acceptsJSON(rq *http.Request) bool {
+	for _, value := range rq.Header.Values("Accept") {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, _, err := mime.ParseMediaType(part)
+			if err == nil && mediaType == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package redirect_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/reddec/redirect"
+)
+
+func ExampleNewTestEngine() {
+	eng := redirect.NewTestEngine(map[string]string{"promo": "https://example.com/{{.Query.c}}"})
+	defer eng.Close()
+
+	rec := httptest.NewRecorder()
+	eng.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/promo?c=mail", nil))
+	fmt.Println(rec.Code, rec.Header().Get("Location"))
+	// Output: 301 https://example.com/mail
+}
+
+func ExampleNewTestEngine_options() {
+	eng := redirect.NewTestEngine(map[string]string{"docs": "https://docs.example.com/"},
+		redirect.WithStatus(http.StatusFound), redirect.WithTrackingParam("ref=short"))
+	defer eng.Close()
+
+	rec := httptest.NewRecorder()
+	eng.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	fmt.Println(rec.Code, rec.Header().Get("Location"))
+	// Output: 302 https://docs.example.com/?ref=short
+}
+
+func ExampleNewTestEngine_resolve() {
+	eng := redirect.NewTestEngine(map[string]string{"promo": "https://example.com/landing"})
+	defer eng.Close()
+
+	target, matched, status, err := eng.Resolve(httptest.NewRequest(http.MethodGet, "/promo", nil))
+	fmt.Println(target, matched, status, err)
+	// Output: https://example.com/landing true 301 <nil>
+}
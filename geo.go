@@ -0,0 +1,106 @@
+package redirect
+
+import (
+	"container/list"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Default maximum number of cached GeoIP lookups.
+const DefaultGeoCache = 10000
+
+// country resolver by MaxMind database (GeoIP2/GeoLite2 Country or City). Results (including unknown) are cached
+// per IP, least recently used entries are evicted.
+type geoIP struct {
+	db    *maxminddb.Reader
+	size  int
+	lock  sync.Mutex
+	keys  map[string]*list.Element
+	order *list.List // of *geoEntry, most recently used first
+}
+
+type geoEntry struct {
+	ip      string
+	country string
+}
+
+// fields of mmdb record used by engine.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+func openGeoIP(file string, size int) (*geoIP, error) {
+	db, err := maxminddb.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	if size < 1 {
+		size = DefaultGeoCache
+	}
+	return &geoIP{
+		db:    db,
+		size:  size,
+		keys:  make(map[string]*list.Element),
+		order: list.New(),
+	}, nil
+}
+
+// country ISO code (upper case) of IP or empty string if unknown.
+func (geo *geoIP) country(ip string) string {
+	geo.lock.Lock()
+	if elem, ok := geo.keys[ip]; ok {
+		geo.order.MoveToFront(elem)
+		geo.lock.Unlock()
+		return elem.Value.(*geoEntry).country
+	}
+	geo.lock.Unlock()
+
+	country := geo.lookup(ip)
+
+	geo.lock.Lock()
+	defer geo.lock.Unlock()
+	if _, ok := geo.keys[ip]; ok {
+		return country
+	}
+	if geo.order.Len() >= geo.size {
+		oldest := geo.order.Back()
+		geo.order.Remove(oldest)
+		delete(geo.keys, oldest.Value.(*geoEntry).ip)
+	}
+	geo.keys[ip] = geo.order.PushFront(&geoEntry{ip: ip, country: country})
+	return country
+}
+
+func (geo *geoIP) lookup(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	var record geoRecord
+	if err := geo.db.Lookup(addr, &record); err != nil {
+		return ""
+	}
+	return strings.ToUpper(record.Country.ISOCode)
+}
+
+func (geo *geoIP) close() error {
+	return geo.db.Close()
+}
+
+// GeoCountry is ISO country code (upper case, ex: DE) of client resolved by GeoIP database. Empty if country is
+// unknown or database is not configured. Database is queried only on first call.
+func (env *TemplateContext) GeoCountry() string {
+	if env.geo == nil {
+		return ""
+	}
+	if !env.geoResolved {
+		env.geoCountry = env.geo.country(env.RemoteIP)
+		env.geoResolved = true
+	}
+	return env.geoCountry
+}
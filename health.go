@@ -0,0 +1,29 @@
+package redirect
+
+import (
+	"net/http"
+)
+
+// HealthHandler always responds 200 OK while server is up (liveness probe).
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, _ *http.Request) {
+		wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = wr.Write([]byte("ok\n"))
+	})
+}
+
+// ReadyHandler responds 200 OK if engine is ready (see Engine.Ready), otherwise 503 Service Unavailable with reason
+// (readiness probe).
+func ReadyHandler(engine Engine) http.Handler {
+	if engine == nil {
+		panic("ready engine ref is nil")
+	}
+	return http.HandlerFunc(func(wr http.ResponseWriter, _ *http.Request) {
+		if err := engine.Ready(); err != nil {
+			http.Error(wr, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = wr.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,38 @@
+package redirect
+
+import (
+	"net/url"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// asciiHost converts internationalized host of target (ex: https://bücher.example) to punycode
+// (https://xn--bcher-kva.example), so Location header is ASCII. Target is returned as-is if host is ASCII or invalid.
+func asciiHost(target string) string {
+	if isASCII(target) {
+		return target
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || isASCII(u.Host) {
+		return target
+	}
+	host, err := idna.Lookup.ToASCII(u.Hostname())
+	if err != nil {
+		return target
+	}
+	if port := u.Port(); port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	return u.String()
+}
+
+func isASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package redirect
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAsciiHost(t *testing.T) {
+	cases := []struct {
+		target string
+		want   string
+	}{
+		{"https://example.com/path", "https://example.com/path"},
+		{"https://bücher.example/katalog?q=1", "https://xn--bcher-kva.example/katalog?q=1"},
+		{"https://bücher.example:8443/", "https://xn--bcher-kva.example:8443/"},
+		{"/café", "/café"}, // relative, no host
+	}
+	for _, c := range cases {
+		if got := asciiHost(c.target); got != c.want {
+			t.Errorf("asciiHost(%q) = %q, want %q", c.target, got, c.want)
+		}
+	}
+}
+
+func TestServeHTTPEncodedPaths(t *testing.T) {
+	eng := NewTestEngine(map[string]string{
+		"café": "https://example.com/cafe",
+		"a/b":  "https://example.com/slash",
+		"idn":  "https://bücher.example/",
+	})
+	defer eng.Close()
+
+	cases := []struct {
+		name     string
+		path     string
+		location string // empty if not matched
+	}{
+		{"utf-8 path", "/café", "https://example.com/cafe"},
+		{"percent-encoded utf-8", "/caf%C3%A9", "https://example.com/cafe"},
+		{"decomposed utf-8 (NFD)", "/cafe%CC%81", "https://example.com/cafe"},
+		{"encoded slash is decoded", "/a%2Fb", "https://example.com/slash"},
+		{"double encoded is decoded once", "/caf%25C3%25A9", ""},
+		{"idn host of target", "/idn", "https://xn--bcher-kva.example/"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		eng.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		location := rec.Header().Get("Location")
+		if c.location == "" {
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s: status %d (location %q), want not found", c.name, rec.Code, location)
+			}
+			continue
+		}
+		if location != c.location {
+			t.Errorf("%s: location %q (status %d), want %q", c.name, location, rec.Code, c.location)
+		}
+	}
+}
+
+func TestServeHTTPRawPath(t *testing.T) {
+	eng := NewTestEngine(map[string]string{
+		"a/b":   "https://example.com/slash",
+		"a%2Fb": "https://example.com/encoded",
+	}, WithRawPath())
+	defer eng.Close()
+
+	for path, want := range map[string]string{"/a/b": "https://example.com/slash", "/a%2Fb": "https://example.com/encoded"} {
+		rec := httptest.NewRecorder()
+		eng.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if location := rec.Header().Get("Location"); location != want {
+			t.Errorf("%s: location %q, want %q", path, location, want)
+		}
+	}
+}
@@ -1,21 +1,94 @@
+// Package redirect is HTTP redirect service: rules map request paths to location templates, engine serves redirects
+// and collects stats, UI manages rules over HTTP.
+//
+// Package can be embedded into other programs: build storage (JSONStorage, SQLite, Postgres or Redis) and stats, create
+// engine, load rules and serve it as regular http.Handler:
+//
+//	storage := &redirect.JSONStorage{FileName: "redir.json"}
+//	stats := redirect.InMemoryStats()
+//	engine, err := redirect.NewEngine(storage, stats, redirect.WithDefaultURL("https://example.com"))
+//	if err != nil {
+//		return err
+//	}
+//	defer engine.Close()
+//	if err := engine.Reload(ctx); err != nil {
+//		return err
+//	}
+//	http.Handle("/", engine)
 package redirect
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
 
-// Engine of all redirection.
+// Wildcard suffix of rule URL: rule docs/* matches docs and any sub-path like docs/intro.
+const Wildcard = "*"
+
+// Engine of all redirection. Engine serves redirects (ServeHTTP) by rules loaded from storage. Rules are not loaded on
+// creation: call Reload before serving and after each change of storage (storages with Watch could notify about
+// changes). Reload prepares new rules aside and replaces loaded rules as whole, so requests are never blocked by
+// reload and each request is matched against rules of single reload. Concurrent reloads are serialized, failed Reload
+// keeps previous rules, stats are not affected by reloads. All methods are safe for concurrent use, Close should be
+// called once after server is stopped.
 type Engine interface {
 	http.Handler
-	Reload() error // reload configuration from storage
+	Reload(ctx context.Context) error                                          // reload configuration from storage, canceled with context
+	Close() error                                                              // flush stats and release storage (if they support io.Closer)
+	Metrics() http.Handler                                                     // Prometheus metrics of redirects
+	Ready() error                                                              // nil if rules are loaded, recent reloads succeeded and storage is reachable
+	Redirect(url string, status int, wr http.ResponseWriter, rq *http.Request) // redirect with tracking parameters of engine
+	IsRegularUser(rq *http.Request) bool                                       // false if visitor is robot by User-Agent
+
+	// Resolve request without writing response: redirect location, whether rule matched, status of response and
+	// template error. The same resolution as ServeHTTP, but visits are not counted.
+	Resolve(rq *http.Request) (target string, matched bool, status int, err error)
 }
 
 // Stats consumer.
 type StatWriter interface {
-	Touch(url string) // Touch resource and increment counter (hot operation, should be fast)
+	Touch(url string, isBot bool) // Touch resource and increment counter of humans or bots (hot operation, should be fast)
 }
 
-// Stats reader.
+// Stats reader. Methods are safe for concurrent use with Touch.
 type StatReader interface {
-	Visits(url string) int64 // Get number of visits for specific service/url
+	Count(url string) (int64, error)   // Get number of visits (humans and bots) for specific service/url
+	Top(n int) ([]ServiceCount, error) // Get up to n most visited services (all if n <= 0), most visited first
+}
+
+// Number of visits of service.
+type ServiceCount struct {
+	URL   string `json:"url"`
+	Count int64  `json:"count"`
+}
+
+// Stats reader of robots visits. Implemented by built-in stats.
+type StatBots interface {
+	Bots(url string) (int64, error) // Get number of visits by robots for specific service/url, included in Count
+}
+
+// Stats reader of visits over time. Implemented by built-in stats.
+type StatSeries interface {
+	Series(url string, from, to time.Time) ([]SeriesPoint, error) // Get visits per time bucket in [from, to), oldest first
+}
+
+// Number of visits in time bucket started at Time.
+type SeriesPoint struct {
+	Time  time.Time `json:"time"`
+	Count int64     `json:"count"`
+}
+
+// Stats which could be reset (ex: after test campaign).
+type StatReset interface {
+	Reset(url string) error // Remove visits (including time series) of specific service/url
+	ResetAll() error        // Remove visits of all services
+}
+
+// Stats which could be saved on demand (ex: before backup). Implemented by built-in stats.
+type StatSnapshot interface {
+	Snapshot(fileName string) error // Write current counters and time buckets to file (JSON, the same as NewJSONStats uses)
 }
 
 // Stats reader and writer.
@@ -26,15 +99,85 @@ type Stats interface {
 
 // Single rule for redirection.
 type Rule struct {
-	URL              string // Matching URL (aka service name)
-	LocationTemplate string // Go-Template of target location
+	URL              string            `json:"url,omitempty"`              // Matching URL (aka service name)
+	LocationTemplate string            `json:"location"`                   // Go-Template of target location
+	Status           int               `json:"status,omitempty"`           // Redirect status code (301, 302, 307, 308). Engine default used if zero
+	Regex            bool              `json:"regex,omitempty"`            // Treat URL as regular expression matched against full path
+	NotBefore        string            `json:"notBefore,omitempty"`        // Rule is not active before this time (RFC3339), optional
+	NotAfter         string            `json:"notAfter,omitempty"`         // Rule is not active after this time (RFC3339), optional
+	Disabled         bool              `json:"disabled,omitempty"`         // Temporary disabled rule handled as missed
+	MaxHits          int64             `json:"maxHits,omitempty"`          // Maximum number of redirects (410 Gone after), zero means unlimited
+	Targets          []Target          `json:"targets,omitempty"`          // Weighted locations (A/B testing). LocationTemplate ignored if set
+	Webhook          string            `json:"webhook,omitempty"`          // URL notified about each redirect, overrides engine webhook
+	NoTracking       bool              `json:"noTracking,omitempty"`       // Never add tracking parameter (urlParameter) to target
+	TreatBotsAsUsers bool              `json:"treatBotsAsUsers,omitempty"` // Add tracking parameter even for robots
+	Countries        map[string]string `json:"countries,omitempty"`        // Location templates by client country code (GeoIP), location or targets used for others
+	Languages        map[string]string `json:"languages,omitempty"`        // Location templates by language tag (Accept-Language), used if country not matched
+	AllowReferers    []string          `json:"allowReferers,omitempty"`    // Referer hosts (example.com or *.example.com) allowed to use rule, any if empty
+	DenyReferers     []string          `json:"denyReferers,omitempty"`     // Referer hosts (example.com or *.example.com) not allowed to use rule
+	Fallback         string            `json:"fallback,omitempty"`         // Go-Template of location used if location (or target) template failed
+	CacheControl     string            `json:"cacheControl,omitempty"`     // Cache-Control header of redirect (ex: no-store), overrides engine default
+	Priority         int               `json:"priority,omitempty"`         // Order of matching wildcard and regex rules and of listing, higher first
+	TrackingParams   map[string]string `json:"trackingParams,omitempty"`   // Override tracking parameters of engine (empty value removes parameter)
+	Refresh          bool              `json:"refresh,omitempty"`          // Respond with HTML page (meta refresh) instead of HTTP redirect
+	Headers          map[string]string `json:"headers,omitempty"`          // Response headers (value is Go-Template), ex: X-Robots-Tag
+	Description      string            `json:"description,omitempty"`      // Free-text notes about rule, not used by engine
+	Tags             []string          `json:"tags,omitempty"`             // Labels to organize and filter rules, not used by engine
+	Schedule         []ScheduledTarget `json:"schedule,omitempty"`         // Locations by weekday and time of day, first matched window used
+	Timezone         string            `json:"timezone,omitempty"`         // IANA time zone of schedule (ex: Europe/Berlin), local time of server if empty
+}
+
+// Weighted location of rule.
+type Target struct {
+	Template string `json:"template"`         // Go-Template of target location
+	Weight   int    `json:"weight,omitempty"` // Relative weight of target, 1 if zero
+}
+
+// Location of rule used during time window.
+type ScheduledTarget struct {
+	When     string `json:"when"`     // Days and time of day: "Mon-Fri 09:00-17:00", "Sat,Sun", "22:00-06:00" (every day)
+	Template string `json:"template"` // Go-Template of target location
+}
+
+// Environment of location template: original request (for backward compatibility) and simplified view of it together
+// with matching details. Values are not escaped.
+type TemplateContext struct {
+	*http.Request
+	Path     string            // Requested path without leading and trailing slashes as-is (not lowered)
+	Query    map[string]string // First value of each query parameter
+	Headers  map[string]string // First value of each request header (canonical names)
+	RemoteIP string            // Client IP address (from forwarding headers of trusted proxies)
+	Tail     string            // Part of path captured by wildcard rule (empty for exact rules)
+	Params   map[string]string // Named capture groups of regex rule (nil for other rules)
+
+	geo         *geoIP // nil if GeoIP is not configured
+	geoResolved bool
+	geoCountry  string
+
+	languageResolved bool
+	language         string
+}
+
+// ErrRuleExists is returned by Creator if rule with the same URL already exists.
+var ErrRuleExists = errors.New("rule already exists")
+
+// Storage which can add rule only if it doesn't exist yet (atomically). Implemented by built-in storages.
+type Creator interface {
+	Create(ctx context.Context, rule Rule) error // add new rule. should return ErrRuleExists if exists and *ValidationError for invalid rule
+}
+
+// Storage which can save and remove several rules in one transaction: all changes are applied or none. Implemented
+// by SQL storages.
+type Batcher interface {
+	Batch(ctx context.Context, put []Rule, remove []string) error // save (add or replace) and remove rules atomically
 }
 
-// Rules storage type.
+// Rules storage type. Remote storages should honor cancellation and deadline of context, local storages could
+// ignore it.
 type Storage interface {
-	Set(url string, locationTemplate string) error // add or replace rule
-	Get(url string) (string, bool)                 // get location template. should return true if exists
-	Remove(url string) error                       // remove rule (or ignore if not exists)
-	All() ([]*Rule, error)                         // dump all save rules
-	Reload() error                                 // reload storage and fill the internal cache
+	Get(ctx context.Context, url string) (Rule, bool, error) // get single rule. should return true if exists
+	Put(ctx context.Context, rule Rule) error                // add or replace rule. should return *ValidationError for invalid rule
+	Delete(ctx context.Context, url string) error            // remove rule (or ignore if not exists)
+	All(ctx context.Context) ([]*Rule, error)                // dump all save rules in order of SortRules
+	Reload(ctx context.Context) error                        // reload storage and fill the internal cache
 }
@@ -0,0 +1,69 @@
+package redirect
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/text/language"
+)
+
+// language based locations of rule.
+type languageTargets struct {
+	tags    []language.Tag
+	targets []*target // by index of tag
+	matcher language.Matcher
+}
+
+func compileLanguages(locations map[string]string) (*languageTargets, error) {
+	keys := make([]string, 0, len(locations))
+	for key := range locations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lt := &languageTargets{}
+	for _, key := range keys {
+		tag, err := language.Parse(key)
+		if err != nil {
+			return nil, fmt.Errorf("parse language %q: %w", key, err)
+		}
+		t, err := parseLocation(locations[key])
+		if err != nil {
+			return nil, fmt.Errorf("parse location of language %s: %w", key, err)
+		}
+		lt.tags = append(lt.tags, tag)
+		lt.targets = append(lt.targets, newTarget(t, 1))
+	}
+	lt.matcher = language.NewMatcher(lt.tags)
+	return lt, nil
+}
+
+// best target for Accept-Language header value and its language. Returns nil if nothing matched.
+func (lt *languageTargets) match(acceptLanguage string) (*target, string) {
+	desired, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(desired) == 0 {
+		return nil, ""
+	}
+	_, index, confidence := lt.matcher.Match(desired...)
+	if confidence == language.No {
+		return nil, ""
+	}
+	return lt.targets[index], lt.tags[index].String()
+}
+
+// Language is the most preferred language of client by Accept-Language header (ex: de-CH), empty if header is
+// missing or invalid. For rules with languages it is the matched language of rule.
+func (env *TemplateContext) Language() string {
+	if !env.languageResolved {
+		env.language = preferredLanguage(env.Request.Header.Get("Accept-Language"))
+		env.languageResolved = true
+	}
+	return env.language
+}
+
+func preferredLanguage(acceptLanguage string) string {
+	desired, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(desired) == 0 {
+		return ""
+	}
+	return desired[0].String()
+}
@@ -0,0 +1,123 @@
+package redirect
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// maximum number of distinct sampled records per period, others are counted together.
+const maxSampleKeys = 10000
+
+// samplingHandler passes at most limit identical warnings and errors (the same level, message and rule or service)
+// per period, so one broken rule hit by many requests doesn't flood logs. Number of dropped records is added to the
+// next passed one as attribute suppressed. Records below warning level are not sampled.
+type samplingHandler struct {
+	next  slog.Handler
+	state *sampleState // shared by derived handlers
+}
+
+type sampleState struct {
+	limit  int
+	period time.Duration
+	lock   sync.Mutex
+	window time.Time // start of current period
+	counts map[sampleKey]*sampleCount
+}
+
+type sampleKey struct {
+	level   slog.Level
+	message string
+	subject string // rule or service of record
+}
+
+type sampleCount struct {
+	passed  int
+	dropped int
+}
+
+func newSamplingHandler(next slog.Handler, limit int, period time.Duration) *samplingHandler {
+	return &samplingHandler{next: next, state: &sampleState{
+		limit:  limit,
+		period: period,
+		counts: make(map[sampleKey]*sampleCount),
+	}}
+}
+
+func (sh *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return sh.next.Enabled(ctx, level)
+}
+
+func (sh *samplingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level < slog.LevelWarn {
+		return sh.next.Handle(ctx, record)
+	}
+	key := sampleKey{level: record.Level, message: record.Message}
+	record.Attrs(func(attr slog.Attr) bool {
+		switch attr.Key {
+		case "rule":
+			key.subject = attr.Value.String()
+			return false
+		case "service":
+			key.subject = attr.Value.String()
+		}
+		return true
+	})
+	dropped, ok := sh.state.allow(key, record.Time)
+	if !ok {
+		return nil
+	}
+	if dropped > 0 {
+		record = record.Clone()
+		record.AddAttrs(slog.Int("suppressed", dropped))
+	}
+	return sh.next.Handle(ctx, record)
+}
+
+func (sh *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{next: sh.next.WithAttrs(attrs), state: sh.state}
+}
+
+func (sh *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{next: sh.next.WithGroup(name), state: sh.state}
+}
+
+// allow record with key at time. Returns number of records dropped since previous passed one and false if the
+// record should be dropped.
+func (ss *sampleState) allow(key sampleKey, at time.Time) (int, bool) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if at.Sub(ss.window) >= ss.period {
+		ss.window = at
+		for k, count := range ss.counts {
+			if count.dropped == 0 {
+				delete(ss.counts, k)
+			} else {
+				count.passed = 0
+			}
+		}
+	}
+	count, ok := ss.counts[key]
+	if !ok {
+		if len(ss.counts) >= maxSampleKeys {
+			key.subject = "" // too many distinct records, count them together
+			count, ok = ss.counts[key]
+		}
+		if !ok {
+			count = &sampleCount{}
+			ss.counts[key] = count
+		}
+	}
+	if count.passed >= ss.limit {
+		count.dropped++
+		return 0, false
+	}
+	count.passed++
+	dropped := count.dropped
+	count.dropped = 0
+	return dropped, true
+}
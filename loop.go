@@ -0,0 +1,88 @@
+package redirect
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// maximum number of static redirects followed by loop detection.
+const maxLoopHops = 32
+
+// findLoop detects static locations (without template actions) which redirect back to the same rule through the
+// engine itself, directly or by other static rules and default URL. Dynamic locations and targets are not checked.
+func (eng *engine) findLoop(rules map[string]*route, fallbacks []*route) error {
+	// default URL redirects missed requests, so it's a loop if it points to missed path
+	if service, ok := eng.ownService(eng.defaultText); ok {
+		if _, _, matched := eng.lookup(rules, fallbacks, service); !matched {
+			return fmt.Errorf("engine: redirect loop: default URL %v is not matched by any rule", eng.defaultText)
+		}
+	}
+	var routes = make([]*route, 0, len(rules)+len(fallbacks))
+	for _, rt := range rules {
+		routes = append(routes, rt)
+	}
+	routes = append(routes, fallbacks...)
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].rule.URL < routes[j].rule.URL
+	})
+
+	for _, start := range routes {
+		chain := []string{start.rule.URL}
+		rt := start
+		for hop := 0; hop < maxLoopHops; hop++ {
+			if len(rt.rule.Targets) > 0 || len(rt.rule.Schedule) > 0 {
+				break
+			}
+			service, ok := eng.ownService(rt.rule.LocationTemplate)
+			if !ok {
+				break
+			}
+			next, _, matched := eng.lookup(rules, fallbacks, service)
+			if !matched {
+				if service, ok = eng.ownService(eng.defaultText); !ok {
+					break
+				}
+				next, _, _ = eng.lookup(rules, fallbacks, service)
+			}
+			chain = append(chain, next.rule.URL)
+			if next == start {
+				return fmt.Errorf("engine: redirect loop: %s", strings.Join(chain, " -> "))
+			}
+			rt = next
+		}
+	}
+	return nil
+}
+
+// ownService returns service name if location is static and points to the engine: absolute path or URL with one of
+// engine hosts.
+func (eng *engine) ownService(location string) (string, bool) {
+	if location == "" || strings.Contains(location, "{{") {
+		return "", false
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", false
+	}
+	if !eng.mounted(u) {
+		return "", false
+	}
+	if u.Host == "" {
+		if u.Scheme != "" || !strings.HasPrefix(u.Path, "/") {
+			return "", false // relative to current path or not HTTP
+		}
+		return eng.normalize(u), true
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "" {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, own := range eng.hosts {
+		if host == own {
+			return eng.normalize(u), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,119 @@
+package redirect
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Default maximum number of distinct service labels in metrics.
+const DefaultMetricsServiceLimit = 1000
+
+// Label of services above the limit of distinct labels.
+const metricsOtherService = "_other"
+
+// Label of rules without tags if hits are counted by tag.
+const metricsUntagged = "_untagged"
+
+// prometheus metrics of engine. Each engine has own registry.
+type metrics struct {
+	registry *prometheus.Registry
+	hits     *prometheus.CounterVec
+	notFound prometheus.Counter
+	errors   prometheus.Counter
+	duration prometheus.Histogram
+	rules    prometheus.Gauge
+	reloaded prometheus.Gauge
+	failures prometheus.Counter // failed reloads
+	limit    int                // maximum number of distinct service labels, non-positive means unlimited
+	byTag    bool               // hits labeled by first tag of rule instead of rule URL
+	lock     sync.RWMutex
+	services map[string]bool
+}
+
+func newMetrics(limit int, byTag bool) *metrics {
+	hits := prometheus.CounterOpts{
+		Name: "redirect_hits_total",
+		Help: "Number of redirects by service (rule URL).",
+	}
+	label := "service"
+	if byTag {
+		hits.Help = "Number of redirects by tag (first tag of rule)."
+		label = "tag"
+	}
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		hits:     prometheus.NewCounterVec(hits, []string{label}),
+		notFound: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "redirect_notfound_total",
+			Help: "Number of requests without matched rule.",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "redirect_errors_total",
+			Help: "Number of requests failed by internal error.",
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "redirect_request_duration_seconds",
+			Help:    "Duration of redirect requests handling.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		rules: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "redirect_rules",
+			Help: "Number of active rules loaded by last successful reload.",
+		}),
+		reloaded: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "redirect_last_reload_success_timestamp_seconds",
+			Help: "Time of last successful reload of rules (Unix seconds).",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "redirect_reload_errors_total",
+			Help: "Number of failed reloads of rules.",
+		}),
+		limit:    limit,
+		byTag:    byTag,
+		services: make(map[string]bool),
+	}
+	m.registry.MustRegister(m.hits, m.notFound, m.errors, m.duration, m.rules, m.reloaded, m.failures)
+	return m
+}
+
+func (m *metrics) hit(rule *Rule) {
+	service := rule.URL
+	if m.byTag {
+		service = metricsUntagged
+		if len(rule.Tags) > 0 {
+			service = rule.Tags[0]
+		}
+	}
+	m.hits.WithLabelValues(m.label(service)).Inc()
+}
+
+// label of service: services above the limit are counted together.
+func (m *metrics) label(service string) string {
+	if m.limit <= 0 {
+		return service
+	}
+	m.lock.RLock()
+	known := m.services[service]
+	size := len(m.services)
+	m.lock.RUnlock()
+	if known {
+		return service
+	}
+	if size >= m.limit {
+		return metricsOtherService
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.services[service] && len(m.services) >= m.limit {
+		return metricsOtherService
+	}
+	m.services[service] = true
+	return service
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
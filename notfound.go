@@ -0,0 +1,40 @@
+package redirect
+
+import (
+	htmltemplate "html/template"
+	"net/http"
+	"strconv"
+)
+
+// parse HTML template of not found page with engine functions.
+func parseNotFoundPage(text string) (*htmltemplate.Template, error) {
+	return htmltemplate.New("").Funcs(htmltemplate.FuncMap(templateFuncs(nil))).Parse(text)
+}
+
+// send not found page (rendered with request view, like rule location) or error if page is not set or client
+// expects JSON.
+func (eng *engine) sendNotFoundPage(wr http.ResponseWriter, rq *http.Request) {
+	if eng.notFoundPage == nil || acceptsJSON(rq) {
+		eng.sendError(wr, rq, eng.notFoundStatus)
+		return
+	}
+	env := &TemplateContext{}
+	env.bind(rq, eng.servicePath(rq), eng.clientIP(rq), eng.geo)
+	tpl, err := eng.notFoundPage.Clone()
+	if err != nil {
+		eng.requestLog.Error("failed clone not found page", "service", env.Path, "err", err)
+		eng.sendError(wr, rq, http.StatusInternalServerError)
+		return
+	}
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+	if err := tpl.Funcs(htmltemplate.FuncMap(templateFuncs(rq))).Execute(buffer, env); err != nil {
+		eng.requestLog.Error("failed execute not found page", "service", env.Path, "err", err)
+		eng.sendError(wr, rq, eng.notFoundStatus)
+		return
+	}
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wr.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+	wr.WriteHeader(eng.notFoundStatus)
+	_, _ = buffer.WriteTo(wr)
+}
@@ -0,0 +1,27 @@
+package redirect
+
+import (
+	"database/sql"
+)
+
+const postgresSchema = `CREATE TABLE IF NOT EXISTS rules (
+	url        TEXT PRIMARY KEY,
+	location   TEXT NOT NULL,
+	attributes JSONB NOT NULL DEFAULT '{}'
+)`
+
+// PostgreSQL storage. Each rule saved as row in table rules, attributes except location saved as JSONB.
+// Driver should be registered by caller (ex: github.com/lib/pq or github.com/jackc/pgx/v5/stdlib).
+type PostgresStorage struct {
+	*sqlStorage
+}
+
+// NewPostgresStorage creates storage over opened database and migrates schema if needed. Storage owns the database
+// and closes it in Close.
+func NewPostgresStorage(db *sql.DB) (*PostgresStorage, error) {
+	ss, err := newSQLStorage(db, "postgres", postgresSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStorage{sqlStorage: ss}, nil
+}
@@ -0,0 +1,72 @@
+package redirect
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Suffix of QR code path of rule in REST API.
+const qrSuffix = "/qr.png"
+
+// Limits of QR code size in pixels.
+const (
+	defaultQRSize = 256
+	maxQRSize     = 2048
+)
+
+// send PNG QR code of public link of rule. Query params: size (pixels) and level (L, M, Q, H) of error correction.
+func (ui *basicUI) qr(service string, wr http.ResponseWriter, rq *http.Request) {
+	query := rq.URL.Query()
+	size := defaultQRSize
+	if value := query.Get("size"); value != "" {
+		v, err := strconv.Atoi(value)
+		if err != nil || v <= 0 || v > maxQRSize {
+			http.Error(wr, "size should be between 1 and "+strconv.Itoa(maxQRSize), http.StatusBadRequest)
+			return
+		}
+		size = v
+	}
+	level := qrcode.Medium
+	switch strings.ToUpper(query.Get("level")) {
+	case "", "M":
+	case "L":
+		level = qrcode.Low
+	case "Q":
+		level = qrcode.High
+	case "H":
+		level = qrcode.Highest
+	default:
+		http.Error(wr, "level should be one of L, M, Q, H", http.StatusBadRequest)
+		return
+	}
+	link := ui.publicLink(service, rq)
+	png, err := qrcode.Encode(link, level, size)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	wr.Header().Set("Content-Type", "image/png")
+	wr.Header().Set("Content-Length", strconv.Itoa(len(png)))
+	_, _ = wr.Write(png)
+}
+
+// public link of service: public URL (if set) or host of request with redirect port.
+func (ui *basicUI) publicLink(service string, rq *http.Request) string {
+	base := strings.TrimSuffix(ui.publicURL, "/")
+	if base == "" {
+		host := rq.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ui.redirPort != "" {
+			host = net.JoinHostPort(host, ui.redirPort)
+		}
+		base = "http://" + host
+	}
+	return base + "/" + (&url.URL{Path: service}).EscapedPath()
+}
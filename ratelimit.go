@@ -0,0 +1,69 @@
+package redirect
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// Default maximum number of tracked clients of rate limiter.
+const DefaultRateLimitClients = 10000
+
+// token bucket rate limiter per key. Number of tracked keys is bounded: least recently used keys are evicted.
+type limiter struct {
+	rate  float64 // tokens per second
+	burst float64
+	size  int
+	lock  sync.Mutex
+	keys  map[string]*list.Element
+	order *list.List // of *tokenBucket, most recently used first
+}
+
+type tokenBucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+}
+
+func newLimiter(rate float64, burst, size int) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	if size < 1 {
+		size = DefaultRateLimitClients
+	}
+	return &limiter{
+		rate:  rate,
+		burst: float64(burst),
+		size:  size,
+		keys:  make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// allow takes token for key. If there are no tokens, returns false and time till next token.
+func (l *limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var tb *tokenBucket
+	if elem, ok := l.keys[key]; ok {
+		l.order.MoveToFront(elem)
+		tb = elem.Value.(*tokenBucket)
+		tb.tokens = math.Min(l.burst, tb.tokens+now.Sub(tb.updated).Seconds()*l.rate)
+		tb.updated = now
+	} else {
+		if l.order.Len() >= l.size {
+			oldest := l.order.Back()
+			l.order.Remove(oldest)
+			delete(l.keys, oldest.Value.(*tokenBucket).key)
+		}
+		tb = &tokenBucket{key: key, tokens: l.burst, updated: now}
+		l.keys[key] = l.order.PushFront(tb)
+	}
+	if tb.tokens < 1 {
+		return false, time.Duration((1 - tb.tokens) / l.rate * float64(time.Second))
+	}
+	tb.tokens--
+	return true, 0
+}
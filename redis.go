@@ -0,0 +1,189 @@
+package redirect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Default prefix of Redis keys.
+const DefaultRedisPrefix = "redirect:"
+
+// Redis storage for multi-instance deployments. Each rule saved as hash (location and JSON attributes) under
+// key <prefix>rule:<url>, set <prefix>rules contains all URLs. Each change is published to <prefix>changes channel,
+// so other instances can reload rules (see Watch).
+type RedisStorage struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStorage creates storage over Redis client. Storage owns the client and closes it in Close.
+func NewRedisStorage(client redis.UniversalClient, prefix string) *RedisStorage {
+	return &RedisStorage{client: client, prefix: prefix}
+}
+
+// Get single rule.
+func (rs *RedisStorage) Get(ctx context.Context, url string) (Rule, bool, error) {
+	fields, err := rs.client.HGetAll(ctx, rs.ruleKey(url)).Result()
+	if err != nil {
+		return Rule{}, false, fmt.Errorf("get rule: %w", err)
+	}
+	rule, ok, err := decodeRedisRule(url, fields)
+	if err != nil || !ok {
+		return Rule{}, false, err
+	}
+	return *rule, true, nil
+}
+
+// Put (add or replace) one valid rule and notify other instances.
+func (rs *RedisStorage) Put(ctx context.Context, rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	attributes, err := encodeRuleAttributes(&rule)
+	if err != nil {
+		return fmt.Errorf("encode attributes: %w", err)
+	}
+	_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, rs.ruleKey(rule.URL), "location", rule.LocationTemplate, "attributes", attributes)
+		pipe.SAdd(ctx, rs.prefix+"rules", rule.URL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("save rule: %w", err)
+	}
+	return rs.notify(ctx, rule.URL)
+}
+
+// Create adds new valid rule (or returns ErrRuleExists) and notifies other instances. Rule is registered in the set
+// of rules first, so concurrent creates of the same URL are resolved by Redis.
+func (rs *RedisStorage) Create(ctx context.Context, rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	attributes, err := encodeRuleAttributes(&rule)
+	if err != nil {
+		return fmt.Errorf("encode attributes: %w", err)
+	}
+	added, err := rs.client.SAdd(ctx, rs.prefix+"rules", rule.URL).Result()
+	if err != nil {
+		return fmt.Errorf("create rule: %w", err)
+	}
+	if added == 0 {
+		return ErrRuleExists
+	}
+	if err := rs.client.HSet(ctx, rs.ruleKey(rule.URL), "location", rule.LocationTemplate, "attributes", attributes).Err(); err != nil {
+		rs.client.SRem(context.WithoutCancel(ctx), rs.prefix+"rules", rule.URL)
+		return fmt.Errorf("create rule: %w", err)
+	}
+	return rs.notify(ctx, rule.URL)
+}
+
+// Delete rule (or ignore if not exists) and notify other instances.
+func (rs *RedisStorage) Delete(ctx context.Context, url string) error {
+	_, err := rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, rs.ruleKey(url))
+		pipe.SRem(ctx, rs.prefix+"rules", url)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("remove rule: %w", err)
+	}
+	return rs.notify(ctx, url)
+}
+
+// All rules from Redis.
+func (rs *RedisStorage) All(ctx context.Context) ([]*Rule, error) {
+	urls, err := rs.client.SMembers(ctx, rs.prefix+"rules").Result()
+	if err != nil {
+		return nil, fmt.Errorf("list rules: %w", err)
+	}
+	var requests = make([]*redis.MapStringStringCmd, len(urls))
+	_, err = rs.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, url := range urls {
+			requests[i] = pipe.HGetAll(ctx, rs.ruleKey(url))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get rules: %w", err)
+	}
+	var ans = make([]*Rule, 0, len(urls))
+	for i, url := range urls {
+		rule, ok, err := decodeRedisRule(url, requests[i].Val())
+		if err != nil {
+			return nil, err
+		}
+		if ok { // could be removed concurrently
+			ans = append(ans, rule)
+		}
+	}
+	SortRules(ans)
+	return ans, nil
+}
+
+// Reload checks Redis connection. There is no internal cache.
+func (rs *RedisStorage) Reload(ctx context.Context) error {
+	return rs.client.Ping(ctx).Err()
+}
+
+// Ping checks Redis connection.
+func (rs *RedisStorage) Ping() error {
+	return rs.client.Ping(context.Background()).Err()
+}
+
+// Watch changes made by any instance and invoke callback. Blocks till context canceled.
+func (rs *RedisStorage) Watch(ctx context.Context, onChange func()) error {
+	sub := rs.client.Subscribe(ctx, rs.prefix+"changes")
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+	changes := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-changes:
+			if !ok {
+				return nil
+			}
+			onChange()
+		}
+	}
+}
+
+// Close underlying client.
+func (rs *RedisStorage) Close() error {
+	return rs.client.Close()
+}
+
+func (rs *RedisStorage) ruleKey(url string) string {
+	return rs.prefix + "rule:" + url
+}
+
+func (rs *RedisStorage) notify(ctx context.Context, url string) error {
+	if err := rs.client.Publish(ctx, rs.prefix+"changes", url).Err(); err != nil {
+		return fmt.Errorf("notify about change: %w", err)
+	}
+	return nil
+}
+
+// decode rule from hash fields. Returns false if rule not exists.
+func decodeRedisRule(url string, fields map[string]string) (*Rule, bool, error) {
+	location, ok := fields["location"]
+	if !ok {
+		return nil, false, nil
+	}
+	var rule Rule
+	if attributes := fields["attributes"]; attributes != "" {
+		if err := json.Unmarshal([]byte(attributes), &rule); err != nil {
+			return nil, false, fmt.Errorf("parse attributes of rule %v: %w", url, err)
+		}
+	}
+	rule.URL = url
+	rule.LocationTemplate = location
+	return &rule, true, nil
+}
@@ -0,0 +1,68 @@
+package redirect
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// compiled referer patterns: exact hosts and suffixes of wildcard hosts (*.example.com).
+type hostList struct {
+	exact    map[string]bool
+	suffixes []string // with leading dot
+}
+
+func compileHosts(patterns []string) (*hostList, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	hl := &hostList{exact: make(map[string]bool)}
+	for _, pattern := range patterns {
+		host := strings.ToLower(strings.TrimSpace(pattern))
+		suffix := strings.HasPrefix(host, "*.")
+		if suffix {
+			host = host[1:]
+		}
+		if host == "" || host == "." || strings.Contains(host, "*") || strings.ContainsAny(host, "/:") {
+			return nil, fmt.Errorf("invalid host pattern %q", pattern)
+		}
+		if suffix {
+			hl.suffixes = append(hl.suffixes, host)
+		} else {
+			hl.exact[host] = true
+		}
+	}
+	return hl, nil
+}
+
+// match host (lower case) against patterns. Wildcard patterns match sub-domains only.
+func (hl *hostList) match(host string) bool {
+	if hl.exact[host] {
+		return true
+	}
+	for _, suffix := range hl.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// refererAllowed checks host of Referer header against rule lists. Requests without (valid) referer are allowed
+// only if rule has no allow list.
+func (rt *route) refererAllowed(referer string) bool {
+	if rt.allowReferers == nil && rt.denyReferers == nil {
+		return true
+	}
+	var host string
+	if u, err := url.Parse(referer); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	if host == "" {
+		return rt.allowReferers == nil
+	}
+	if rt.denyReferers != nil && rt.denyReferers.match(host) {
+		return false
+	}
+	return rt.allowReferers == nil || rt.allowReferers.match(host)
+}
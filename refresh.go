@@ -0,0 +1,59 @@
+package redirect
+
+import (
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// page for clients which don't follow HTTP redirects (webviews, email clients): meta refresh with link fallback.
+// Target is used only in URL contexts, never in script.
+var refreshPage = htmltemplate.Must(htmltemplate.New("").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url={{.}}">
+<title>Redirecting</title>
+</head>
+<body><a href="{{.}}">Continue</a></body>
+</html>
+`))
+
+// refresh page should be sent instead of redirect: rule requires it or request has refresh parameter.
+func (eng *engine) refresh(rule *Rule, rq *http.Request) bool {
+	if rule != nil && rule.Refresh {
+		return true
+	}
+	if eng.refreshParam == "" {
+		return false
+	}
+	value, _ := strconv.ParseBool(rq.URL.Query().Get(eng.refreshParam))
+	return value
+}
+
+// refreshable checks that target could be put to refresh page: http(s) or relative URL.
+func refreshable(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	return scheme == "" || scheme == "http" || scheme == "https"
+}
+
+// send refresh page to target with 200 OK.
+func (eng *engine) sendRefresh(wr http.ResponseWriter, url string) {
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+	if err := refreshPage.Execute(buffer, url); err != nil {
+		eng.requestLog.Error("failed execute refresh page", "target", url, "err", err)
+		http.Error(wr, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wr.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+	wr.WriteHeader(http.StatusOK)
+	_, _ = buffer.WriteTo(wr)
+}
@@ -0,0 +1,53 @@
+package redirect
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRefreshPage(t *testing.T) {
+	eng := NewTestEngine(map[string]string{
+		"promo": "https://example.com/?a=1&b=2",
+		"path":  "/promo",
+		"js":    "javascript:alert(document.domain)",
+	}, WithRefreshParam("_refresh"))
+	defer eng.Close()
+
+	for _, service := range []string{"promo", "path"} {
+		rec := httptest.NewRecorder()
+		eng.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+service+"?_refresh=1", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want %d", service, rec.Code, http.StatusOK)
+		}
+		body := rec.Body.String()
+		if strings.Contains(body, "<script") {
+			t.Errorf("%s: page contains script: %s", service, body)
+		}
+		if !strings.Contains(body, `http-equiv="refresh"`) {
+			t.Errorf("%s: page without meta refresh: %s", service, body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	eng.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/js?_refresh=1", nil))
+	if rec.Code != http.StatusMovedPermanently || strings.Contains(rec.Body.String(), "http-equiv") {
+		t.Errorf("javascript target rendered as page: status %d, body %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRefreshPageForwardQuery(t *testing.T) {
+	eng := NewTestEngine(map[string]string{"promo": "https://example.com/land"}, WithRefreshParam("_refresh"), WithForwardQuery())
+	defer eng.Close()
+
+	rec := httptest.NewRecorder()
+	eng.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/promo?b=2&_refresh=1", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, "b=2") {
+		t.Fatalf("status %d, page without forwarded query: %s", rec.Code, body)
+	}
+	if strings.Contains(body, "_refresh") {
+		t.Errorf("refresh parameter forwarded to target: %s", body)
+	}
+}
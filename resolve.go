@@ -0,0 +1,172 @@
+package redirect
+
+import (
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const resolvePath = "_resolve" // preview of redirect target
+
+// Resolution is preview of request handling: matched rule and redirect target. Stats, limits of requests and webhooks
+// are not affected.
+type Resolution struct {
+	Service string `json:"service"`          // normalized path of request
+	Rule    *Rule  `json:"rule,omitempty"`   // matched rule, nil if no rule matched
+	Target  string `json:"target,omitempty"` // redirect location with forwarded query and tracking parameters
+	Status  int    `json:"status"`           // status of response: redirect or error
+	Bot     bool   `json:"bot"`              // visitor is treated as robot
+	Error   string `json:"error,omitempty"`  // template error, if any
+}
+
+// resolver is implemented by engines which can preview redirects.
+type resolver interface {
+	resolve(rq *http.Request) *Resolution
+}
+
+// decision is outcome of request resolution shared by ServeHTTP, Resolve and preview.
+type decision struct {
+	service  string
+	rt       *route           // matched rule, nil if no rule matched
+	env      *TemplateContext // template environment of matched rule
+	admitted bool             // matched rule serves request now (visit is counted)
+	missed   bool             // handled as not matched: default URL or not found page
+	blocked  bool             // target is not allowed
+	target   string           // rendered target without forwarded query and tracking parameters
+	status   int              // status of response
+	err      error            // template error, if any
+}
+
+// redirects returns true if response is redirect to target.
+func (d *decision) redirects() bool {
+	return d.status >= 300 && d.status < 400
+}
+
+// rule which target is used, nil for default URL.
+func (d *decision) rule() *Rule {
+	if d.rt == nil || d.missed {
+		return nil
+	}
+	return d.rt.rule
+}
+
+// decide how to handle request: find rule, check that it serves request and render target (or default URL).
+// Visits are not counted and response is not written.
+func (eng *engine) decide(rq *http.Request) *decision {
+	d := &decision{service: eng.servicePath(rq)}
+	if !eng.mounted(rq.URL) {
+		d.status = http.StatusNotFound
+		return d
+	}
+
+	status := statusNotMatched
+	rt, env, ok := eng.match(d.service)
+	if ok {
+		d.rt, d.env = rt, env
+		status = eng.admit(rt, d.service, rq)
+	}
+
+	if status == 0 {
+		d.admitted = true
+		d.target, d.err = eng.target(rt, env, d.service, rq)
+		if d.err == nil {
+			d.status = rt.status(eng.status)
+			if !eng.targetAllowed(d.target) {
+				eng.requestLog.Warn("target is not allowed", "service", d.service, "rule", rt.rule.URL, "target", d.target)
+				trace.SpanFromContext(rq.Context()).SetAttributes(attribute.Bool("redirect.blocked", true))
+				d.blocked, d.status = true, http.StatusForbidden
+			}
+			return d
+		}
+		if !eng.tplFallback {
+			d.status = http.StatusInternalServerError
+			return d
+		}
+		status = statusNotMatched
+	}
+
+	if status != statusNotMatched {
+		d.status = status
+		return d
+	}
+
+	d.missed = true
+	if eng.defaultUrl == nil {
+		d.status = eng.notFoundStatus
+		return d
+	}
+	target, err := eng.defaultTarget(rq)
+	if err != nil {
+		d.status, d.err = http.StatusInternalServerError, err
+		return d
+	}
+	d.target, d.status = target, eng.status
+	if !eng.targetAllowed(target) {
+		eng.requestLog.Warn("default target is not allowed", "service", d.service, "target", target)
+		d.blocked, d.status = true, http.StatusForbidden
+	}
+	return d
+}
+
+// Resolve request the same way as ServeHTTP without writing response. Target is location of redirect (with forwarded
+// query and tracking parameters), empty if response is not redirect. Matched is true if request is handled by rule
+// (not by default URL or not found page). Status is status of response and err is template error, if any.
+// Visits are not counted and webhooks are not sent. Limit of requests and robots.txt are not checked.
+func (eng *engine) Resolve(rq *http.Request) (target string, matched bool, status int, err error) {
+	d := eng.decide(rq)
+	if d.redirects() {
+		target = eng.location(d.rule(), d.target, rq)
+	}
+	return target, d.rule() != nil, d.status, d.err
+}
+
+// resolve request the same way as ServeHTTP, but without side effects.
+func (eng *engine) resolve(rq *http.Request) *Resolution {
+	d := eng.decide(rq)
+	res := &Resolution{Service: d.service, Status: d.status, Bot: !eng.IsRegularUser(rq)}
+	if d.rt != nil {
+		res.Rule = d.rt.rule
+	}
+	if d.err != nil {
+		res.Error = d.err.Error()
+	}
+	switch {
+	case d.blocked:
+		res.Target = d.target
+	case d.redirects():
+		res.Target = eng.location(d.rule(), d.target, rq)
+	}
+	return res
+}
+
+// preview redirect of request described by query parameters: path, query (raw query string) and ua (User-Agent).
+// Client address of the preview request is used as visitor address.
+func (ui *basicUI) resolve(wr http.ResponseWriter, rq *http.Request) {
+	if rq.Method != http.MethodGet {
+		wr.Header().Set("Allow", "GET")
+		http.Error(wr, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	engine, ok := ui.engine.(resolver)
+	if !ok {
+		http.Error(wr, "engine doesn't support preview", http.StatusNotImplemented)
+		return
+	}
+	params := rq.URL.Query()
+	path := params.Get("path")
+	if path == "" {
+		http.Error(wr, "path is required", http.StatusBadRequest)
+		return
+	}
+	target := &url.URL{Path: path, RawQuery: params.Get("query")}
+	preview, err := http.NewRequestWithContext(rq.Context(), http.MethodGet, target.String(), nil)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	preview.RemoteAddr = rq.RemoteAddr
+	preview.Header.Set("User-Agent", params.Get("ua"))
+	sendJSON(engine.resolve(preview), wr)
+}
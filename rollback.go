@@ -0,0 +1,155 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+)
+
+// previous state of rules changed by mutation, used to restore them if engine can't load new rules.
+type rollback struct {
+	rules map[string]*Rule // previous rules by URL, nil if rule didn't exist
+	full  bool             // rules contain all rules, others should be removed on restore
+}
+
+// snapshot rules with provided URLs.
+func snapshotRules(ctx context.Context, storage Storage, urls ...string) (*rollback, error) {
+	rb := &rollback{rules: make(map[string]*Rule, len(urls))}
+	for _, url := range urls {
+		rule, exists, err := storage.Get(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			rb.rules[url] = &rule
+		} else {
+			rb.rules[url] = nil
+		}
+	}
+	return rb, nil
+}
+
+// snapshot all rules (for bulk mutations).
+func snapshotAll(ctx context.Context, storage Storage) (*rollback, error) {
+	rules, err := storage.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rb := &rollback{rules: make(map[string]*Rule, len(rules)), full: true}
+	for _, rule := range rules {
+		rb.rules[rule.URL] = rule
+	}
+	return rb, nil
+}
+
+// restore rules state.
+func (rb *rollback) restore(ctx context.Context, storage Storage) error {
+	if rb.full {
+		current, err := storage.All(ctx)
+		if err != nil {
+			return err
+		}
+		for _, rule := range current {
+			if _, known := rb.rules[rule.URL]; !known {
+				if err := storage.Delete(ctx, rule.URL); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for url, rule := range rb.rules {
+		var err error
+		if rule == nil {
+			err = storage.Delete(ctx, url)
+		} else {
+			err = storage.Put(ctx, *rule)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reloadError means that storage was changed, but engine failed to load new rules, so the change was rolled back.
+type reloadError struct {
+	Err error
+}
+
+func (re *reloadError) Error() string {
+	return fmt.Sprintf("changes rolled back: %v", re.Err)
+}
+
+func (re *reloadError) Unwrap() error {
+	return re.Err
+}
+
+// apply mutation and reload engine. If reload failed, rules are restored from snapshot and reloadError returned.
+// Applied changes are recorded to audit log.
+func (ui *basicUI) apply(rq *http.Request, rb *rollback, mutate func() error) error {
+	invalid := ui.invalidRules()
+	err := mutate()
+	if err != nil {
+		ui.audit(rq.Context(), rb) // mutation could be partially applied
+		return err
+	}
+	err = ui.engine.Reload(rq.Context())
+	if err == nil {
+		err = newlyInvalid(invalid, ui.invalidRules()) // skipped by engine, but mutation should not be accepted
+	}
+	if err == nil {
+		ui.audit(rq.Context(), rb)
+		return nil
+	}
+	// restore even if request is canceled
+	ctx := context.WithoutCancel(rq.Context())
+	if restoreErr := rb.restore(ctx, ui.storage); restoreErr != nil {
+		slog.Error("failed restore rules after failed reload", "err", restoreErr)
+		return fmt.Errorf("reload: %w; restore: %v", err, restoreErr)
+	}
+	if reloadErr := ui.engine.Reload(ctx); reloadErr != nil {
+		slog.Error("failed reload restored rules", "err", reloadErr)
+	}
+	return &reloadError{Err: err}
+}
+
+// errors of invalid rules skipped by engine, nil if engine doesn't report them.
+func (ui *basicUI) invalidRules() map[string]string {
+	if engine, ok := ui.engine.(reporter); ok {
+		return engine.state().Invalid
+	}
+	return nil
+}
+
+// error of the first (by URL) rule which became invalid after mutation.
+func newlyInvalid(before, after map[string]string) error {
+	var urls []string
+	for url, reason := range after {
+		if before[url] != reason {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	sort.Strings(urls)
+	return errors.New(after[urls[0]])
+}
+
+// send error of mutation: 400 for invalid rules, 409 if rule exists or rules can't be loaded together,
+// 500 otherwise.
+func sendMutationError(wr http.ResponseWriter, err error) {
+	var invalid *ValidationError
+	var reload *reloadError
+	switch {
+	case errors.As(err, &invalid):
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+	case errors.As(err, &reload), errors.Is(err, ErrRuleExists):
+		http.Error(wr, err.Error(), http.StatusConflict)
+	default:
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,405 @@
+package redirect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode"
+)
+
+// compiled rule.
+type route struct {
+	rule          *Rule
+	targets       []*target          // location templates, at least one
+	countries     map[string]*target // location templates by country code
+	languages     *languageTargets   // nil if rule has no languages
+	fallback      *target            // location if template of target failed, optional
+	headers       map[string]*target // response header templates by canonical name
+	allowReferers *hostList          // nil if not restricted
+	denyReferers  *hostList          // nil if not restricted
+	weights       int                // sum of targets weights
+	prefix        string             // path prefix for wildcard rules
+	pattern       *regexp.Regexp     // compiled URL for regex rules
+	notBefore     time.Time          // zero if not limited
+	notAfter      time.Time          // zero if not limited
+	schedule      []*window          // scheduled locations in order, optional
+	timezone      *time.Location     // location of schedule, nil for local time
+}
+
+// active checks that rule schedule contains the time.
+func (rt *route) active(now time.Time) bool {
+	return (rt.notBefore.IsZero() || !now.Before(rt.notBefore)) && !rt.expired(now)
+}
+
+// expired checks that rule schedule ended before the time.
+func (rt *route) expired(now time.Time) bool {
+	return !rt.notAfter.IsZero() && now.After(rt.notAfter)
+}
+
+// redirect status of rule or fallback if not defined.
+func (rt *route) status(fallback int) int {
+	if rt.rule.Status != 0 {
+		return rt.rule.Status
+	}
+	return fallback
+}
+
+// one of rule locations.
+type target struct {
+	location *template.Template
+	weight   int
+	static   bool      // template has no actions, so it's always rendered to value
+	value    string    // pre-rendered location of static template
+	bound    sync.Pool // *boundTemplate clones of location, reused between requests
+}
+
+// clone of location template with functions bound to request of current render.
+type boundTemplate struct {
+	tpl *template.Template
+	rq  *http.Request
+}
+
+// newTarget detects static templates and pre-renders them.
+func newTarget(location *template.Template, weight int) *target {
+	t := &target{location: location, weight: weight}
+	if text, ok := staticText(location); ok {
+		t.static = true
+		t.value = strings.TrimSpace(text)
+	}
+	return t
+}
+
+// staticText returns content of template without actions.
+func staticText(tpl *template.Template) (string, bool) {
+	if tpl.Tree == nil || tpl.Tree.Root == nil {
+		return "", true
+	}
+	var text strings.Builder
+	for _, node := range tpl.Tree.Root.Nodes {
+		textNode, ok := node.(*parse.TextNode)
+		if !ok {
+			return "", false
+		}
+		text.Write(textNode.Text)
+	}
+	return text.String(), true
+}
+
+// ValidationError describes invalid rule.
+type ValidationError struct {
+	URL string // URL of rule
+	Err error  // reason
+}
+
+func (ve *ValidationError) Error() string {
+	return "invalid rule " + strconv.Quote(ve.URL) + ": " + ve.Err.Error()
+}
+
+func (ve *ValidationError) Unwrap() error {
+	return ve.Err
+}
+
+// Validate rule: URL must be non-empty and all attributes (templates, status, schedule) should be parseable.
+// Returns *ValidationError.
+func (r *Rule) Validate() error {
+	if r.URL == "" {
+		return &ValidationError{URL: r.URL, Err: errors.New("empty url")}
+	}
+	if _, err := compileRule(r); err != nil {
+		return &ValidationError{URL: r.URL, Err: err}
+	}
+	return nil
+}
+
+// compileRule parses templates and attributes of rule. Prefix for wildcard rules is not set.
+func compileRule(rule *Rule) (*route, error) {
+	if rule.Status != 0 && !isRedirectStatus(rule.Status) {
+		return nil, fmt.Errorf("unsupported redirect status %d", rule.Status)
+	}
+	rt := &route{rule: rule}
+	var err error
+	if len(rule.Targets) == 0 {
+		t, err := parseLocation(rule.LocationTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("parse location: %w", err)
+		}
+		rt.targets = append(rt.targets, newTarget(t, 1))
+	}
+	for i, info := range rule.Targets {
+		t, err := parseLocation(info.Template)
+		if err != nil {
+			return nil, fmt.Errorf("parse location of target #%d: %w", i, err)
+		}
+		weight := info.Weight
+		if weight < 0 {
+			return nil, fmt.Errorf("negative weight of target #%d", i)
+		} else if weight == 0 {
+			weight = 1
+		}
+		rt.targets = append(rt.targets, newTarget(t, weight))
+	}
+	for _, t := range rt.targets {
+		rt.weights += t.weight
+	}
+	for country, location := range rule.Countries {
+		if len(country) != 2 {
+			return nil, fmt.Errorf("country code %q should be two letters", country)
+		}
+		t, err := parseLocation(location)
+		if err != nil {
+			return nil, fmt.Errorf("parse location of country %s: %w", country, err)
+		}
+		if rt.countries == nil {
+			rt.countries = make(map[string]*target, len(rule.Countries))
+		}
+		rt.countries[strings.ToUpper(country)] = newTarget(t, 1)
+	}
+	if rule.Fallback != "" {
+		t, err := parseLocation(rule.Fallback)
+		if err != nil {
+			return nil, fmt.Errorf("parse fallback: %w", err)
+		}
+		rt.fallback = newTarget(t, 1)
+	}
+	for _, tag := range rule.Tags {
+		if strings.TrimSpace(tag) == "" || strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("tag %q should be non-empty and without commas", tag)
+		}
+	}
+	for name, value := range rule.Headers {
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		if !validHeaderName(key) || key == "Location" || key == "Content-Length" {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		t, err := parseLocation(value)
+		if err != nil {
+			return nil, fmt.Errorf("parse header %s: %w", key, err)
+		}
+		if rt.headers == nil {
+			rt.headers = make(map[string]*target, len(rule.Headers))
+		}
+		rt.headers[key] = newTarget(t, 1)
+	}
+	if len(rule.Schedule) > 0 {
+		rt.schedule, err = compileSchedule(rule.Schedule)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rule.Timezone != "" {
+		rt.timezone, err = time.LoadLocation(rule.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("load timezone: %w", err)
+		}
+	}
+	if len(rule.Languages) > 0 {
+		rt.languages, err = compileLanguages(rule.Languages)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rt.allowReferers, err = compileHosts(rule.AllowReferers)
+	if err != nil {
+		return nil, fmt.Errorf("allowed referers: %w", err)
+	}
+	rt.denyReferers, err = compileHosts(rule.DenyReferers)
+	if err != nil {
+		return nil, fmt.Errorf("denied referers: %w", err)
+	}
+	if rule.NotBefore != "" {
+		rt.notBefore, err = time.Parse(time.RFC3339, rule.NotBefore)
+		if err != nil {
+			return nil, fmt.Errorf("parse not-before time: %w", err)
+		}
+	}
+	if rule.NotAfter != "" {
+		rt.notAfter, err = time.Parse(time.RFC3339, rule.NotAfter)
+		if err != nil {
+			return nil, fmt.Errorf("parse not-after time: %w", err)
+		}
+	}
+	if rule.Webhook != "" {
+		if u, err := url.Parse(rule.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook should be absolute http(s) URL")
+		}
+	}
+	if rule.Regex {
+		rt.pattern, err = regexp.Compile("^(?:" + rule.URL + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("compile regex: %w", err)
+		}
+	}
+	return rt, nil
+}
+
+// header name is non-empty token (RFC 9110).
+func validHeaderName(name string) bool {
+	return name != "" && strings.IndexFunc(name, func(r rune) bool {
+		return r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("!#$%&'*+-.^_`|~", r))
+	}) < 0
+}
+
+// parse location template with engine functions.
+func parseLocation(text string) (*template.Template, error) {
+	return template.New("").Funcs(templateFuncs(nil)).Parse(text)
+}
+
+// pick target by weight. Non-empty sticky value always selects the same target (while targets are not changed).
+func (rt *route) pick(sticky string) *target {
+	if len(rt.targets) == 1 {
+		return rt.targets[0]
+	}
+	var point int
+	if sticky != "" {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(sticky))
+		point = int(hash.Sum32() % uint32(rt.weights))
+	} else {
+		point = rand.Intn(rt.weights) // nolint:gosec
+	}
+	for _, t := range rt.targets {
+		if point < t.weight {
+			return t
+		}
+		point -= t.weight
+	}
+	return rt.targets[len(rt.targets)-1]
+}
+
+// target for current time window, client country or language (if rule has such) or picked by weight.
+func (rt *route) choose(env *TemplateContext, sticky string, now time.Time) *target {
+	if t := rt.scheduled(now); t != nil {
+		return t
+	}
+	if len(rt.countries) > 0 {
+		if t, ok := rt.countries[env.GeoCountry()]; ok {
+			return t
+		}
+	}
+	if rt.languages != nil {
+		if t, lang := rt.languages.match(env.Request.Header.Get("Accept-Language")); t != nil {
+			env.language = lang
+			env.languageResolved = true
+			return t
+		}
+	}
+	return rt.pick(sticky)
+}
+
+// fill request view in template environment.
+func (env *TemplateContext) bind(rq *http.Request, path string, clientIP string, geo *geoIP) {
+	env.Request = rq
+	env.geo = geo
+	env.Path = path
+	env.Query = firstValues(rq.URL.Query())
+	env.Headers = firstValues(rq.Header)
+	env.RemoteIP = clientIP
+}
+
+// firstValues of multi-value map.
+func firstValues(values map[string][]string) map[string]string {
+	var ans = make(map[string]string, len(values))
+	for key, list := range values {
+		if len(list) > 0 {
+			ans[key] = list[0]
+		}
+	}
+	return ans
+}
+
+// render location template of target. Template functions are bound to the request. Static targets are returned
+// without execution.
+func (t *target) render(env *TemplateContext) (string, error) {
+	if t.static {
+		return t.value, nil
+	}
+	bound, err := t.bind()
+	if err != nil {
+		return "", err
+	}
+	bound.rq = env.Request
+	defer func() {
+		bound.rq = nil
+		t.bound.Put(bound)
+	}()
+	urlData := getBuffer()
+	defer putBuffer(urlData)
+	err = bound.tpl.Execute(urlData, env)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(urlData.String()), nil
+}
+
+// bind returns pooled clone of location or makes new one. Functions of clone read request from it, so clone is
+// reused without rebuilding functions.
+func (t *target) bind() (*boundTemplate, error) {
+	if bound, ok := t.bound.Get().(*boundTemplate); ok {
+		return bound, nil
+	}
+	tpl, err := t.location.Clone()
+	if err != nil {
+		return nil, err
+	}
+	bound := &boundTemplate{}
+	bound.tpl = tpl.Funcs(requestFuncs(func() *http.Request { return bound.rq }))
+	return bound, nil
+}
+
+// buffers for rendering templates, reused between requests.
+var bufferPool = sync.Pool{ // nolint:gochecknoglobals
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// Buffers larger than this are not returned to pool to not keep memory after rare huge renders.
+const maxPooledBuffer = 64 << 10
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() > maxPooledBuffer {
+		return
+	}
+	buffer.Reset()
+	bufferPool.Put(buffer)
+}
+
+// templateFuncs available in location templates. Request could be nil for parsing.
+//
+//	queryGet "key"  - value of query parameter, escaped for query
+//	header "Name"   - value of request header, escaped for query
+//	pathEscape      - escape value for path segment
+//	queryEscape     - escape value for query (same as builtin urlquery but for single string)
+func templateFuncs(rq *http.Request) template.FuncMap {
+	return requestFuncs(func() *http.Request { return rq })
+}
+
+// requestFuncs are template functions which get request on each call.
+func requestFuncs(request func() *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"queryGet": func(key string) string {
+			return url.QueryEscape(request().URL.Query().Get(key))
+		},
+		"header": func(name string) string {
+			return url.QueryEscape(request().Header.Get(name))
+		},
+		"pathEscape":  url.PathEscape,
+		"queryEscape": url.QueryEscape,
+	}
+}
@@ -0,0 +1,151 @@
+package redirect
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// time window of scheduled location: days of week and time of day (minutes since midnight, end exclusive).
+type window struct {
+	days  [7]bool // by time.Weekday
+	start int
+	end   int // could be less than start for windows over midnight
+	loc   *target
+}
+
+// compileSchedule parses windows of rule in order.
+func compileSchedule(schedule []ScheduledTarget) ([]*window, error) {
+	var windows = make([]*window, 0, len(schedule))
+	for i, info := range schedule {
+		w, err := parseWindow(info.When)
+		if err != nil {
+			return nil, fmt.Errorf("parse window of schedule #%d: %w", i, err)
+		}
+		t, err := parseLocation(info.Template)
+		if err != nil {
+			return nil, fmt.Errorf("parse location of schedule #%d: %w", i, err)
+		}
+		w.loc = newTarget(t, 1)
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseWindow parses window like "Mon-Fri 09:00-17:00", "Sat,Sun" (whole days) or "22:00-06:00" (every day).
+func parseWindow(text string) (*window, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("window %q should be days, time range or both", text)
+	}
+	w := &window{end: 24 * 60}
+	var days, hours string
+	switch {
+	case len(fields) == 2:
+		days, hours = fields[0], fields[1]
+	case strings.Contains(fields[0], ":"):
+		hours = fields[0]
+	default:
+		days = fields[0]
+	}
+	if days == "" {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	} else if err := w.parseDays(days); err != nil {
+		return nil, err
+	}
+	if hours != "" {
+		from, to, ok := strings.Cut(hours, "-")
+		if !ok {
+			return nil, fmt.Errorf("time range %q should be like 09:00-17:00", hours)
+		}
+		var err error
+		if w.start, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		if w.start == w.end {
+			return nil, fmt.Errorf("time range %q is empty", hours)
+		}
+	}
+	return w, nil
+}
+
+// parse comma separated days and ranges of days (ex: Mon-Fri,Sun). Ranges could wrap over week end (Fri-Mon).
+func (w *window) parseDays(text string) error {
+	for _, part := range strings.Split(text, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := parseWeekday(from)
+		if err != nil {
+			return err
+		}
+		last := first
+		if isRange {
+			if last, err = parseWeekday(to); err != nil {
+				return err
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseWeekday parses short (Mon) or full (Monday) English name of day, case-insensitive.
+func parseWeekday(text string) (time.Weekday, error) {
+	name := strings.ToLower(text)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", text)
+}
+
+// parseClock parses time of day (HH:MM, 24:00 allowed) as minutes since midnight.
+func parseClock(text string) (int, error) {
+	var hour, minute int
+	if n, err := fmt.Sscanf(text, "%d:%d", &hour, &minute); err != nil || n != 2 || len(text) != 5 {
+		return 0, fmt.Errorf("time %q should be HH:MM", text)
+	}
+	if minute < 0 || minute > 59 || hour < 0 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("time %q is out of range", text)
+	}
+	return hour*60 + minute, nil
+}
+
+// contains checks that window includes the time (in location of rule). Windows over midnight belong to the day they
+// started.
+func (w *window) contains(now time.Time) bool {
+	minute := now.Hour()*60 + now.Minute()
+	day := now.Weekday()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	if minute >= w.start {
+		return w.days[day]
+	}
+	return minute < w.end && w.days[(day+6)%7]
+}
+
+// scheduled target for the time: first window containing it, nil if none.
+func (rt *route) scheduled(now time.Time) *target {
+	if len(rt.schedule) == 0 {
+		return nil
+	}
+	if rt.timezone != nil {
+		now = now.In(rt.timezone)
+	}
+	for _, w := range rt.schedule {
+		if w.contains(now) {
+			return w.loc
+		}
+	}
+	return nil
+}
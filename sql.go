@@ -0,0 +1,209 @@
+package redirect
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Common implementation of SQL storages (SQLite, PostgreSQL) with prepared statements. Each rule saved as row
+// of table rules, attributes except location saved as JSON.
+type sqlStorage struct {
+	db     *sql.DB
+	name   string // prefix of errors (sqlite, postgres)
+	put    *sql.Stmt
+	create *sql.Stmt
+	get    *sql.Stmt
+	delete *sql.Stmt
+	all    *sql.Stmt
+}
+
+// create schema and prepare statements.
+func newSQLStorage(db *sql.DB, name string, schema string) (*sqlStorage, error) {
+	if _, err := db.Exec(schema); err != nil {
+		return nil, fmt.Errorf("%s: create schema: %w", name, err)
+	}
+	ss := &sqlStorage{db: db, name: name}
+	for _, q := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&ss.put, `INSERT INTO rules (url, location, attributes) VALUES ($1, $2, $3)
+			ON CONFLICT (url) DO UPDATE SET location = excluded.location, attributes = excluded.attributes`},
+		{&ss.create, `INSERT INTO rules (url, location, attributes) VALUES ($1, $2, $3) ON CONFLICT (url) DO NOTHING`},
+		{&ss.get, `SELECT url, location, attributes FROM rules WHERE url = $1`},
+		{&ss.delete, `DELETE FROM rules WHERE url = $1`},
+		{&ss.all, `SELECT url, location, attributes FROM rules`},
+	} {
+		stmt, err := db.Prepare(q.query)
+		if err != nil {
+			ss.closeStatements()
+			return nil, fmt.Errorf("%s: prepare statement: %w", name, err)
+		}
+		*q.stmt = stmt
+	}
+	return ss, nil
+}
+
+// Get single rule.
+func (ss *sqlStorage) Get(ctx context.Context, url string) (Rule, bool, error) {
+	rule, err := scanRule(ss.get.QueryRowContext(ctx, url))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Rule{}, false, nil
+	} else if err != nil {
+		return Rule{}, false, ss.errorf("get rule: %w", err)
+	}
+	return *rule, true, nil
+}
+
+// Put (add or replace) one valid rule.
+func (ss *sqlStorage) Put(ctx context.Context, rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	attributes, err := encodeRuleAttributes(&rule)
+	if err != nil {
+		return ss.errorf("encode attributes: %w", err)
+	}
+	if _, err := ss.put.ExecContext(ctx, rule.URL, rule.LocationTemplate, attributes); err != nil {
+		return ss.errorf("save rule: %w", err)
+	}
+	return nil
+}
+
+// Create adds new valid rule or returns ErrRuleExists.
+func (ss *sqlStorage) Create(ctx context.Context, rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	attributes, err := encodeRuleAttributes(&rule)
+	if err != nil {
+		return ss.errorf("encode attributes: %w", err)
+	}
+	res, err := ss.create.ExecContext(ctx, rule.URL, rule.LocationTemplate, attributes)
+	if err != nil {
+		return ss.errorf("create rule: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ss.errorf("create rule: %w", err)
+	} else if n == 0 {
+		return ErrRuleExists
+	}
+	return nil
+}
+
+// Batch saves and removes rules in one transaction. All rules are validated before changes.
+func (ss *sqlStorage) Batch(ctx context.Context, put []Rule, remove []string) error {
+	var attributes = make([]string, len(put))
+	for i := range put {
+		if err := put[i].Validate(); err != nil {
+			return err
+		}
+		encoded, err := encodeRuleAttributes(&put[i])
+		if err != nil {
+			return ss.errorf("encode attributes: %w", err)
+		}
+		attributes[i] = encoded
+	}
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ss.errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback() // nolint:errcheck
+	putStmt, deleteStmt := tx.StmtContext(ctx, ss.put), tx.StmtContext(ctx, ss.delete)
+	for i, rule := range put {
+		if _, err := putStmt.ExecContext(ctx, rule.URL, rule.LocationTemplate, attributes[i]); err != nil {
+			return ss.errorf("save rule %v: %w", rule.URL, err)
+		}
+	}
+	for _, url := range remove {
+		if _, err := deleteStmt.ExecContext(ctx, url); err != nil {
+			return ss.errorf("remove rule %v: %w", url, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return ss.errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Delete rule (or ignore if not exists).
+func (ss *sqlStorage) Delete(ctx context.Context, url string) error {
+	if _, err := ss.delete.ExecContext(ctx, url); err != nil {
+		return ss.errorf("remove rule: %w", err)
+	}
+	return nil
+}
+
+// All rules from database.
+func (ss *sqlStorage) All(ctx context.Context) ([]*Rule, error) {
+	rows, err := ss.all.QueryContext(ctx)
+	if err != nil {
+		return nil, ss.errorf("query rules: %w", err)
+	}
+	defer rows.Close()
+	var ans []*Rule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, ss.errorf("%w", err)
+		}
+		ans = append(ans, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ss.errorf("query rules: %w", err)
+	}
+	SortRules(ans)
+	return ans, nil
+}
+
+// Reload checks database connection. There is no internal cache.
+func (ss *sqlStorage) Reload(ctx context.Context) error {
+	if err := ss.db.PingContext(ctx); err != nil {
+		return ss.errorf("ping: %w", err)
+	}
+	return nil
+}
+
+// Ping checks database connection.
+func (ss *sqlStorage) Ping() error {
+	return ss.db.Ping()
+}
+
+// Close prepared statements and underlying database.
+func (ss *sqlStorage) Close() error {
+	ss.closeStatements()
+	return ss.db.Close()
+}
+
+// errorf formats error of storage prefixed by its name.
+func (ss *sqlStorage) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf(ss.name+": "+format, args...)
+}
+
+func (ss *sqlStorage) closeStatements() {
+	for _, stmt := range []*sql.Stmt{ss.put, ss.create, ss.get, ss.delete, ss.all} {
+		if stmt != nil {
+			_ = stmt.Close()
+		}
+	}
+}
+
+// scan rule from row of url, location and attributes.
+func scanRule(row interface {
+	Scan(dest ...interface{}) error
+}) (*Rule, error) {
+	var url, location, attributes string
+	if err := row.Scan(&url, &location, &attributes); err != nil {
+		return nil, fmt.Errorf("scan rule: %w", err)
+	}
+	var rule Rule
+	if err := json.Unmarshal([]byte(attributes), &rule); err != nil {
+		return nil, fmt.Errorf("parse attributes of rule %v: %w", url, err)
+	}
+	rule.URL = url
+	rule.LocationTemplate = location
+	return &rule, nil
+}
@@ -0,0 +1,81 @@
+package redirect
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// testSQLite creates SQLite storage in temporary directory.
+func testSQLite(t testing.TB, rules ...Rule) *SQLiteStorage {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "rules.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := NewSQLiteStorage(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = storage.Close() })
+	for _, rule := range rules {
+		if err := storage.Put(context.Background(), rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return storage
+}
+
+func TestSQLBatch(t *testing.T) {
+	ctx := context.Background()
+	storage := testSQLite(t,
+		Rule{URL: "a", LocationTemplate: "https://example.com/a"},
+		Rule{URL: "b", LocationTemplate: "https://example.com/b"},
+	)
+	err := storage.Batch(ctx, []Rule{{URL: "a", LocationTemplate: "https://example.com/a", Disabled: true}}, []string{"b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule, _, _ := storage.Get(ctx, "a"); !rule.Disabled {
+		t.Error("rule a is not disabled")
+	}
+	if _, exists, _ := storage.Get(ctx, "b"); exists {
+		t.Error("rule b is not removed")
+	}
+}
+
+func TestSQLBatchRollback(t *testing.T) {
+	ctx := context.Background()
+	storage := testSQLite(t,
+		Rule{URL: "a", LocationTemplate: "https://example.com/a"},
+		Rule{URL: "locked", LocationTemplate: "https://example.com/locked"},
+	)
+	// fail removal in the middle of transaction
+	_, err := storage.db.Exec(`CREATE TRIGGER keep_locked BEFORE DELETE ON rules WHEN old.url = 'locked'
+		BEGIN SELECT RAISE(ABORT, 'locked'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.Batch(ctx, []Rule{{URL: "a", LocationTemplate: "https://example.com/a", Disabled: true}}, []string{"locked"})
+	if err == nil {
+		t.Fatal("batch should fail")
+	}
+	if rule, _, _ := storage.Get(ctx, "a"); rule.Disabled {
+		t.Error("change of rule a is not rolled back")
+	}
+}
+
+func TestSQLErrorPrefix(t *testing.T) {
+	storage := testSQLite(t)
+	if _, err := storage.db.Exec(`DROP TABLE rules`); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := storage.Get(context.Background(), "a")
+	if err == nil || !strings.HasPrefix(err.Error(), "sqlite: ") {
+		t.Errorf("error %v is not prefixed by storage name", err)
+	}
+}
@@ -0,0 +1,28 @@
+package redirect
+
+import (
+	"database/sql"
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS rules (
+	url        TEXT PRIMARY KEY,
+	location   TEXT NOT NULL,
+	attributes TEXT NOT NULL DEFAULT '{}'
+)`
+
+// SQLite storage. Each rule saved as row in table rules, attributes except location saved as JSON.
+// Driver should be registered by caller (ex: modernc.org/sqlite or github.com/mattn/go-sqlite3).
+type SQLiteStorage struct {
+	*sqlStorage
+}
+
+// NewSQLiteStorage creates storage over opened database and creates schema if needed. Storage owns the database and
+// closes it in Close.
+func NewSQLiteStorage(db *sql.DB) (*SQLiteStorage, error) {
+	db.SetMaxOpenConns(1) // SQLite doesn't support concurrent writes
+	ss, err := newSQLStorage(db, "sqlite", sqliteSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteStorage{sqlStorage: ss}, nil
+}
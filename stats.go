@@ -1,43 +1,450 @@
 package redirect
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// Default granularity and number of time buckets kept per service (one week by hours).
+const (
+	DefaultBucketSize  = time.Hour
+	DefaultBucketCount = 24 * 7
+)
+
+// StatsOption configures built-in stats.
+type StatsOption func(ms *inMemoryStat)
+
+// WithBuckets sets granularity (ex: time.Hour or 24*time.Hour) and number of time buckets kept per service.
+// Buckets are aligned to UTC. Zero count disables time series, as well as invalid values (non-positive size or
+// negative count), so callers should validate user input.
+func WithBuckets(size time.Duration, count int) StatsOption {
+	return func(ms *inMemoryStat) {
+		if size <= 0 || count < 0 {
+			ms.bucketSize, ms.bucketCount = DefaultBucketSize, 0
+			return
+		}
+		ms.bucketSize = size
+		ms.bucketCount = count
+	}
+}
+
+// number of independently locked parts of services map, reduces contention of Touch for many services.
+const statShards = 32
+
+// inMemoryStat keeps services in shards by hash of URL. Counters are atomic, so Touch of known service takes only read
+// lock of its shard. Readers aggregate shards on query.
 type inMemoryStat struct {
-	cache map[string]*int64
+	shards      [statShards]statShard
+	bucketSize  time.Duration
+	bucketCount int
+}
+
+type statShard struct {
 	lock  sync.RWMutex
+	cache map[string]*serviceStat
+	_     [64]byte // padding to keep locks of shards in different cache lines
+}
+
+// counters of single service.
+type serviceStat struct {
+	hits    int64 // atomic
+	bots    int64 // atomic, included in hits
+	lock    sync.Mutex
+	buckets []bucket // ring, indexed by bucket number modulo length
+}
+
+// time bucket. Fields are atomic, lock of service is only taken to reuse bucket for newer number.
+type bucket struct {
+	number int64 // number of bucket since epoch
+	count  int64
 }
 
-func InMemoryStats() Stats {
-	return &inMemoryStat{
-		cache: make(map[string]*int64),
+// InMemoryStats keeps total visits and ring of time buckets (DefaultBucketSize x DefaultBucketCount by default)
+// per service.
+func InMemoryStats(options ...StatsOption) Stats {
+	return newInMemoryStat(options...)
+}
+
+func newInMemoryStat(options ...StatsOption) *inMemoryStat {
+	ms := &inMemoryStat{
+		bucketSize:  DefaultBucketSize,
+		bucketCount: DefaultBucketCount,
+	}
+	for i := range ms.shards {
+		ms.shards[i].cache = make(map[string]*serviceStat)
+	}
+	for _, opt := range options {
+		opt(ms)
+	}
+	return ms
+}
+
+func (ms *inMemoryStat) Touch(url string, isBot bool) {
+	val := ms.service(url)
+	atomic.AddInt64(&val.hits, 1)
+	if isBot {
+		atomic.AddInt64(&val.bots, 1)
 	}
+	ms.add(val, time.Now(), 1)
 }
 
-func (ms *inMemoryStat) Touch(url string) {
-	ms.lock.RLock()
-	val, ok := ms.cache[url]
-	ms.lock.RUnlock()
+// shard of service by FNV-1a hash of URL.
+func (ms *inMemoryStat) shard(url string) *statShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(url); i++ {
+		hash ^= uint32(url[i])
+		hash *= 16777619
+	}
+	return &ms.shards[hash%statShards]
+}
+
+// get or create counters of service.
+func (ms *inMemoryStat) service(url string) *serviceStat {
+	shard := ms.shard(url)
+	shard.lock.RLock()
+	val, ok := shard.cache[url]
+	shard.lock.RUnlock()
+	if ok {
+		return val
+	}
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	val, ok = shard.cache[url]
 	if !ok {
-		ms.lock.Lock()
-		val, ok = ms.cache[url]
-		if !ok {
-			val = new(int64)
-			ms.cache[url] = val
+		val = &serviceStat{buckets: make([]bucket, ms.bucketCount)}
+		shard.cache[url] = val
+	}
+	return val
+}
+
+// counters of service or nil if service has no visits.
+func (ms *inMemoryStat) lookup(url string) *serviceStat {
+	shard := ms.shard(url)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	return shard.cache[url]
+}
+
+// call fn for each service under read lock of its shard.
+func (ms *inMemoryStat) each(fn func(url string, val *serviceStat)) {
+	for i := range ms.shards {
+		shard := &ms.shards[i]
+		shard.lock.RLock()
+		for url, val := range shard.cache {
+			fn(url, val)
 		}
-		ms.lock.Unlock()
+		shard.lock.RUnlock()
 	}
-	atomic.AddInt64(val, 1)
 }
 
-func (ms *inMemoryStat) Visits(url string) int64 {
-	ms.lock.RLock()
-	val, ok := ms.cache[url]
-	ms.lock.RUnlock()
-	if !ok {
-		return 0
+// add visits to time bucket. Buckets older than ring are reused.
+func (ms *inMemoryStat) add(val *serviceStat, at time.Time, count int64) {
+	if ms.bucketCount <= 0 {
+		return
+	}
+	number := ms.bucketNumber(at)
+	slot := &val.buckets[number%int64(ms.bucketCount)]
+	if atomic.LoadInt64(&slot.number) == number {
+		atomic.AddInt64(&slot.count, count)
+		return
+	}
+	val.lock.Lock()
+	defer val.lock.Unlock()
+	if current := atomic.LoadInt64(&slot.number); current != number {
+		if current > number {
+			return // too old
+		}
+		atomic.StoreInt64(&slot.count, 0)
+		atomic.StoreInt64(&slot.number, number)
+	}
+	atomic.AddInt64(&slot.count, count)
+}
+
+func (ms *inMemoryStat) bucketNumber(at time.Time) int64 {
+	return at.UnixNano() / int64(ms.bucketSize)
+}
+
+// copy of all counters.
+func (ms *inMemoryStat) snapshot() map[string]int64 {
+	var ans = make(map[string]int64)
+	ms.each(func(url string, val *serviceStat) {
+		ans[url] = atomic.LoadInt64(&val.hits)
+	})
+	return ans
+}
+
+// copy of non-zero robots counters.
+func (ms *inMemoryStat) botsSnapshot() map[string]int64 {
+	var ans = make(map[string]int64)
+	ms.each(func(url string, val *serviceStat) {
+		if bots := atomic.LoadInt64(&val.bots); bots > 0 {
+			ans[url] = bots
+		}
+	})
+	return ans
+}
+
+func (ms *inMemoryStat) Count(url string) (int64, error) {
+	val := ms.lookup(url)
+	if val == nil {
+		return 0, nil
+	}
+	return atomic.LoadInt64(&val.hits), nil
+}
+
+func (ms *inMemoryStat) Bots(url string) (int64, error) {
+	val := ms.lookup(url)
+	if val == nil {
+		return 0, nil
+	}
+	return atomic.LoadInt64(&val.bots), nil
+}
+
+// Series of visits per bucket in [from, to). Only buckets kept in ring are returned, empty buckets have zero count.
+func (ms *inMemoryStat) Series(url string, from, to time.Time) ([]SeriesPoint, error) {
+	if ms.bucketCount <= 0 || !from.Before(to) {
+		return nil, nil
+	}
+	first := ms.bucketNumber(from)
+	last := ms.bucketNumber(to.Add(-1))
+	if oldest := ms.bucketNumber(time.Now()) - int64(ms.bucketCount) + 1; first < oldest {
+		first = oldest
+	}
+	if first > last {
+		return nil, nil
+	}
+	val := ms.lookup(url)
+	var ans = make([]SeriesPoint, 0, last-first+1)
+	for number := first; number <= last; number++ {
+		ans = append(ans, SeriesPoint{Time: time.Unix(0, number*int64(ms.bucketSize)).UTC()})
+	}
+	if val == nil {
+		return ans, nil
+	}
+	for i := range ans {
+		number := first + int64(i)
+		if count, ok := val.bucket(number%int64(ms.bucketCount), number); ok {
+			ans[i].Count = count
+		}
+	}
+	return ans, nil
+}
+
+// count of slot if it holds bucket with provided number.
+func (val *serviceStat) bucket(slot int64, number int64) (int64, bool) {
+	val.lock.Lock() // excludes reuse of bucket between reads of number and count
+	defer val.lock.Unlock()
+	b := &val.buckets[slot]
+	if atomic.LoadInt64(&b.number) != number {
+		return 0, false
+	}
+	return atomic.LoadInt64(&b.count), true
+}
+
+// copy of non-empty buckets of all services, oldest first.
+func (ms *inMemoryStat) seriesSnapshot() map[string][]SeriesPoint {
+	var ans = make(map[string][]SeriesPoint)
+	ms.each(func(url string, val *serviceStat) {
+		var points []SeriesPoint
+		val.lock.Lock()
+		for i := range val.buckets {
+			slot := &val.buckets[i]
+			if count := atomic.LoadInt64(&slot.count); count > 0 {
+				points = append(points, SeriesPoint{Time: time.Unix(0, atomic.LoadInt64(&slot.number)*int64(ms.bucketSize)).UTC(), Count: count})
+			}
+		}
+		val.lock.Unlock()
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Time.Before(points[j].Time)
+		})
+		if len(points) > 0 {
+			ans[url] = points
+		}
+	})
+	return ans
+}
+
+// Top services by visits. Counters are copied under read locks of shards and sorted outside them, so writers are not
+// blocked.
+func (ms *inMemoryStat) Top(n int) ([]ServiceCount, error) {
+	var ans []ServiceCount
+	ms.each(func(url string, val *serviceStat) {
+		ans = append(ans, ServiceCount{URL: url, Count: atomic.LoadInt64(&val.hits)})
+	})
+	sort.Slice(ans, func(i, j int) bool {
+		if ans[i].Count != ans[j].Count {
+			return ans[i].Count > ans[j].Count
+		}
+		return ans[i].URL < ans[j].URL
+	})
+	if n > 0 && len(ans) > n {
+		ans = ans[:n]
+	}
+	return ans, nil
+}
+
+// Reset removes counters of service. Never returns error.
+func (ms *inMemoryStat) Reset(url string) error {
+	shard := ms.shard(url)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	delete(shard.cache, url)
+	return nil
+}
+
+// ResetAll removes counters of all services. Never returns error.
+func (ms *inMemoryStat) ResetAll() error {
+	for i := range ms.shards {
+		shard := &ms.shards[i]
+		shard.lock.Lock()
+		shard.cache = make(map[string]*serviceStat)
+		shard.lock.Unlock()
+	}
+	return nil
+}
+
+// Snapshot writes current counters and time buckets to file atomically. The file could be loaded by NewJSONStats.
+func (ms *inMemoryStat) Snapshot(fileName string) error {
+	data, err := json.MarshalIndent(&jsonStatsFile{
+		Counts: ms.snapshot(),
+		Bots:   ms.botsSnapshot(),
+		Series: ms.seriesSnapshot(),
+	}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshal stats: %w", err)
+	}
+	return writeFileAtomic(fileName, data, 0600)
+}
+
+// JSONStats is in-memory stats persisted to JSON file. Counters and time buckets are flushed to disk periodically
+// (only if changed) and on Close.
+type JSONStats struct {
+	*inMemoryStat
+	fileName string
+	touched  int32 // non-zero if there are changes after last flush
+	stop     chan struct{}
+	done     chan struct{}
+	closing  sync.Once
+}
+
+// content of stats file. Legacy files contain plain map of counters.
+type jsonStatsFile struct {
+	Counts map[string]int64         `json:"counts"`
+	Bots   map[string]int64         `json:"bots,omitempty"`
+	Series map[string][]SeriesPoint `json:"series,omitempty"`
+}
+
+// NewJSONStats loads stats from file (if exists) and starts background flush with provided interval. Non-positive
+// interval disables background flush, so stats are saved only by Flush and Close.
+func NewJSONStats(fileName string, flushInterval time.Duration, options ...StatsOption) (*JSONStats, error) {
+	js := &JSONStats{
+		inMemoryStat: newInMemoryStat(options...),
+		fileName:     fileName,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read stats: %w", err)
+	}
+	if err == nil {
+		content, err := parseJSONStats(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse stats: %w", err)
+		}
+		for url, count := range content.Counts {
+			js.service(url).hits = count
+		}
+		for url, count := range content.Bots {
+			js.service(url).bots = count
+		}
+		for url, points := range content.Series {
+			val := js.service(url)
+			for _, point := range points {
+				js.add(val, point.Time, point.Count)
+			}
+		}
+	}
+	go js.flushLoop(flushInterval)
+	return js, nil
+}
+
+func parseJSONStats(data []byte) (*jsonStatsFile, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	var content jsonStatsFile
+	if counts, ok := fields["counts"]; ok && bytes.HasPrefix(bytes.TrimSpace(counts), []byte("{")) {
+		return &content, json.Unmarshal(data, &content)
+	}
+	return &content, json.Unmarshal(data, &content.Counts)
+}
+
+func (js *JSONStats) Touch(url string, isBot bool) {
+	js.inMemoryStat.Touch(url, isBot)
+	atomic.StoreInt32(&js.touched, 1)
+}
+
+// Reset removes counters of service, change is saved with next flush.
+func (js *JSONStats) Reset(url string) error {
+	atomic.StoreInt32(&js.touched, 1)
+	return js.inMemoryStat.Reset(url)
+}
+
+// ResetAll removes counters of all services, change is saved with next flush.
+func (js *JSONStats) ResetAll() error {
+	atomic.StoreInt32(&js.touched, 1)
+	return js.inMemoryStat.ResetAll()
+}
+
+// Flush counters and time buckets to disk. Changes are kept as not saved if write failed, so they are retried by
+// next background flush.
+func (js *JSONStats) Flush() error {
+	atomic.StoreInt32(&js.touched, 0) // before snapshot, so touches during write are not lost
+	if err := js.Snapshot(js.fileName); err != nil {
+		atomic.StoreInt32(&js.touched, 1)
+		return err
+	}
+	return nil
+}
+
+// Close stops background flush and flushes counters last time. Safe to call several times.
+func (js *JSONStats) Close() error {
+	js.closing.Do(func() {
+		close(js.stop)
+		<-js.done
+	})
+	return js.Flush()
+}
+
+func (js *JSONStats) flushLoop(interval time.Duration) {
+	defer close(js.done)
+	if interval <= 0 {
+		<-js.stop
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-js.stop:
+			return
+		case <-ticker.C:
+			if atomic.LoadInt32(&js.touched) == 0 {
+				continue
+			}
+			if err := js.Flush(); err != nil {
+				slog.Error("failed flush stats", "file", js.fileName, "err", err)
+			}
+		}
 	}
-	return *val
 }
@@ -0,0 +1,129 @@
+package redirect
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTouchWithTop(t *testing.T) {
+	stats := InMemoryStats(WithBuckets(time.Millisecond, 4))
+	services := []string{"a", "b", "c"}
+	const writers, touches = 8, 5000
+
+	var wg, reader sync.WaitGroup
+	done := make(chan struct{})
+	reader.Add(1)
+	go func() { // reader repeatedly builds top while writers touch
+		defer reader.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			top, err := stats.Top(2)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(top) > 2 {
+				t.Errorf("top returned %d services, want at most 2", len(top))
+				return
+			}
+		}
+	}()
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < touches; i++ {
+				stats.Touch(services[i%len(services)], i%5 == 0)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	reader.Wait()
+
+	top, err := stats.Top(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for i, item := range top {
+		total += item.Count
+		if i > 0 && top[i-1].Count < item.Count {
+			t.Errorf("top is not sorted: %+v", top)
+		}
+	}
+	if total != writers*touches {
+		t.Errorf("total hits %d, want %d", total, writers*touches)
+	}
+}
+
+func BenchmarkTouchParallel(b *testing.B) {
+	stats := InMemoryStats()
+	services := make([]string, 64)
+	for i := range services {
+		services[i] = "service" + strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			stats.Touch(services[i%len(services)], false)
+			i++
+		}
+	})
+}
+
+func TestWithBucketsInvalid(t *testing.T) {
+	for _, c := range []struct {
+		size  time.Duration
+		count int
+	}{{0, 10}, {-time.Hour, 10}, {time.Hour, -1}} {
+		stats := InMemoryStats(WithBuckets(c.size, c.count))
+		stats.Touch("promo", false) // should not panic
+		if count, _ := stats.Count("promo"); count != 1 {
+			t.Errorf("size %v, count %d: hits %d, want 1", c.size, c.count, count)
+		}
+	}
+}
+
+func TestJSONStatsFailedFlush(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "missing", "stats.json")
+	stats, err := NewJSONStats(fileName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats.Touch("promo", false)
+	if err := stats.Flush(); err == nil {
+		t.Fatal("flush to missing directory should fail")
+	}
+	if stats.touched == 0 {
+		t.Fatal("changes are marked as saved after failed flush")
+	}
+	if err := os.Mkdir(filepath.Join(dir, "missing"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := stats.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stats.Close(); err != nil { // second close should not panic
+		t.Fatal(err)
+	}
+	loaded, err := NewJSONStats(fileName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	if count, _ := loaded.Count("promo"); count != 1 {
+		t.Errorf("loaded hits %d, want 1", count)
+	}
+}
@@ -0,0 +1,70 @@
+package redirect
+
+import (
+	"net/http"
+	"time"
+)
+
+const statusPath = "_status" // state of loaded rules
+
+// EngineStatus is state of loaded rules: when they were loaded and how many are active, together with outcome of
+// recent reloads. Stale rules (ex: silently failed file watch or SIGHUP reload) are visible by LoadedAt and Failures.
+type EngineStatus struct {
+	LoadedAt  *time.Time        `json:"loadedAt,omitempty"`  // time of last successful reload, nil if rules are not loaded
+	Rules     int               `json:"rules"`               // active (not disabled) rules
+	Exact     int               `json:"exact"`               // rules matched by exact URL
+	Wildcards int               `json:"wildcards"`           // rules matched by prefix
+	Patterns  int               `json:"patterns"`            // rules matched by regular expression
+	Invalid   map[string]string `json:"invalid,omitempty"`   // errors of invalid rules skipped by last successful reload, by URL
+	Failures  int               `json:"failures"`            // consecutive failed reloads
+	FailedAt  *time.Time        `json:"failedAt,omitempty"`  // time of last failed reload, if any
+	LastError string            `json:"lastError,omitempty"` // error of last reload if it failed
+}
+
+// reporter is implemented by engines which can report state of loaded rules.
+type reporter interface {
+	state() *EngineStatus
+}
+
+func (eng *engine) state() *EngineStatus {
+	set := eng.current.Load()
+	st := &EngineStatus{}
+	st.Exact, st.Wildcards, st.Patterns = set.counts()
+	st.Rules = st.Exact + st.Wildcards + st.Patterns
+	for url, err := range set.invalid {
+		if st.Invalid == nil {
+			st.Invalid = make(map[string]string, len(set.invalid))
+		}
+		st.Invalid[url] = err.Error()
+	}
+	if !set.loadedAt.IsZero() {
+		loadedAt := set.loadedAt
+		st.LoadedAt = &loadedAt
+	}
+	eng.lock.RLock()
+	defer eng.lock.RUnlock()
+	st.Failures = eng.failures
+	if !eng.failedAt.IsZero() {
+		failedAt := eng.failedAt
+		st.FailedAt = &failedAt
+	}
+	if eng.reloadErr != nil {
+		st.LastError = eng.reloadErr.Error()
+	}
+	return st
+}
+
+// status of loaded rules (read-only).
+func (ui *basicUI) status(wr http.ResponseWriter, rq *http.Request) {
+	if rq.Method != http.MethodGet {
+		wr.Header().Set("Allow", "GET")
+		http.Error(wr, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	engine, ok := ui.engine.(reporter)
+	if !ok {
+		http.Error(wr, "engine doesn't report status", http.StatusNotImplemented)
+		return
+	}
+	sendJSON(engine.state(), wr)
+}
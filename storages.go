@@ -1,93 +1,320 @@
 package redirect
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
-// Simple single-file storage. All rules saved as-is by JSON indented encoder to the provided file after each Set ops.
+// Delay after last change of config file before reload. Editors usually write file several times.
+const watchDebounce = 500 * time.Millisecond
+
+// prefix of commented out keys in JSON config.
+const jsonComment = "#"
+
+// Simple single-file storage. All rules saved as-is by JSON indented encoder to the provided file after each Put ops.
+// File is replaced atomically, so it's never left partially written.
+//
+// Each rule saved as object keyed by URL. Rules without additional attributes saved as plain location string
+// (legacy format), so old configs are still readable.
+//
+// Keys started by # are comments (ex: "#promo" for temporary commented out rule). They are not loaded as rules, but
+// kept in file as-is, the same as entries which can't be decoded as rules (ex: wrong type of attribute). Broken
+// entries are logged and skipped on reload, unless Strict is set.
 type JSONStorage struct {
-	FileName string // File name to store and read
-	cache    map[string]string
+	FileName string // File name to store and read, rules are kept only in memory if empty
+	Strict   bool   // Fail reload if any entry can't be decoded as rule, instead of skipping it
+	cache    map[string]*Rule
+	kept     map[string]json.RawMessage // comments and skipped entries, written back as-is
 	lock     sync.RWMutex
 }
 
-// Set or replace one rule, serialize cache to JSON and then dump to disk. Even if dump failed rule is saved into cache.
-func (js *JSONStorage) Set(url string, locationTemplate string) error {
+// Get copy of single rule from cache. Never returns error.
+func (js *JSONStorage) Get(_ context.Context, url string) (Rule, bool, error) {
+	js.lock.RLock()
+	defer js.lock.RUnlock()
+	v, ok := js.cache[url]
+	if !ok {
+		return Rule{}, false, nil
+	}
+	return *v, true, nil
+}
+
+// Put (add or replace) one valid rule, serialize cache to JSON and then dump to disk. Even if dump failed rule is saved
+// into cache.
+func (js *JSONStorage) Put(_ context.Context, rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
 	js.lock.Lock()
 	defer js.lock.Unlock()
 	if js.cache == nil {
-		js.cache = make(map[string]string)
+		js.cache = make(map[string]*Rule)
 	}
-	js.cache[url] = locationTemplate
+	js.cache[rule.URL] = &rule
+	delete(js.kept, rule.URL)
 	return js.unsafeDump()
 }
 
-// Get single record from cache.
-func (js *JSONStorage) Get(url string) (string, bool) {
-	js.lock.RLock()
-	defer js.lock.RUnlock()
-	v, ok := js.cache[url]
-	return v, ok
+// Create adds new valid rule (see Put) or returns ErrRuleExists.
+func (js *JSONStorage) Create(_ context.Context, rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	if _, exists := js.cache[rule.URL]; exists {
+		return ErrRuleExists
+	}
+	if js.cache == nil {
+		js.cache = make(map[string]*Rule)
+	}
+	js.cache[rule.URL] = &rule
+	delete(js.kept, rule.URL)
+	return js.unsafeDump()
 }
 
-// Remove rule from cache and save dump to disk. Even if dump failed rule removed from cache.
-func (js *JSONStorage) Remove(url string) error {
-	if js.cache == nil {
-		return nil
+// CreateRule adds rule to storage only if it doesn't exist, otherwise returns ErrRuleExists. Check is atomic for
+// storages implementing Creator.
+func CreateRule(ctx context.Context, storage Storage, rule Rule) error {
+	if creator, ok := storage.(Creator); ok {
+		return creator.Create(ctx, rule)
+	}
+	_, exists, err := storage.Get(ctx, rule.URL)
+	if err != nil {
+		return err
 	}
+	if exists {
+		return ErrRuleExists
+	}
+	return storage.Put(ctx, rule)
+}
+
+// Delete rule from cache and save dump to disk. Even if dump failed rule removed from cache.
+func (js *JSONStorage) Delete(_ context.Context, url string) error {
 	js.lock.Lock()
 	defer js.lock.Unlock()
+	if js.cache == nil {
+		return nil
+	}
 	delete(js.cache, url)
+	delete(js.kept, url)
 	return js.unsafeDump()
 }
 
 // All rules stored in cache. Never returns error.
-func (js *JSONStorage) All() ([]*Rule, error) {
-	var ans = make([]*Rule, 0, len(js.cache))
+func (js *JSONStorage) All(_ context.Context) ([]*Rule, error) {
 	js.lock.RLock()
 	defer js.lock.RUnlock()
-	for url, location := range js.cache {
-		ans = append(ans, &Rule{
-			URL:              url,
-			LocationTemplate: location,
-		})
+	var ans = make([]*Rule, 0, len(js.cache))
+	for _, rule := range js.cache {
+		cp := *rule
+		ans = append(ans, &cp)
 	}
+	SortRules(ans)
 	return ans, nil
 }
 
+// SortRules orders rules by priority (higher first) and then by URL. Storages return rules in this order.
+func SortRules(rules []*Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		return rules[i].URL < rules[j].URL
+	})
+}
+
 // Read all rules from file. Will not update cache if file will not exists.
-func (js *JSONStorage) Reload() error {
+func (js *JSONStorage) Reload(_ context.Context) error {
+	if js.FileName == "" {
+		return nil // in-memory only
+	}
 	js.lock.RLock() // prevent read and write the same file
 	data, err := ioutil.ReadFile(js.FileName)
 	js.lock.RUnlock()
 	if os.IsNotExist(err) {
 		// nothing to reload
-		log.Println(js.FileName, err)
+		slog.Warn("config file not found", "file", js.FileName)
 		return nil
 	} else if err != nil {
 		return fmt.Errorf("read JSON config: %w", err)
 	}
-	var cache map[string]string
-	err = json.Unmarshal(data, &cache)
+	return js.Load(bytes.NewReader(data))
+}
+
+// Load rules from JSON config stream (ex: stdin) instead of file and replace cached ones. File is not changed, so
+// storage without file name keeps loaded rules (and changes of them) only in memory.
+func (js *JSONStorage) Load(r io.Reader) error {
+	var kept = make(map[string]json.RawMessage)
+	cache, err := readBundle(r, kept, !js.Strict)
 	if err != nil {
 		// failed to decode json - mb broken?
 		return fmt.Errorf("parse JSON config: %w", err)
 	}
+	for url := range kept {
+		if !strings.HasPrefix(url, jsonComment) {
+			slog.Warn("invalid rule in config skipped", "file", js.FileName, "url", url)
+		}
+	}
 	js.lock.Lock()
 	js.cache = cache
+	js.kept = kept
 	js.lock.Unlock()
 	return nil
 }
 
+// Watch config file and reload it after changes. The onChange callback is invoked after successful reload only,
+// failed reloads are logged. Blocks till context canceled.
+func (js *JSONStorage) Watch(ctx context.Context, onChange func()) error {
+	if js.FileName == "" {
+		return errors.New("config is not a file")
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("create watcher: %w", err)
+	}
+	defer watcher.Close()
+	// watch directory instead of file, because editors may replace file
+	if err := watcher.Add(filepath.Dir(js.FileName)); err != nil {
+		return fmt.Errorf("watch config directory: %w", err)
+	}
+	name := filepath.Clean(js.FileName)
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == name && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				debounce.Reset(watchDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Error("failed watch config", "file", js.FileName, "err", err)
+		case <-debounce.C:
+			if err := js.Reload(ctx); err != nil {
+				slog.Error("failed reload changed config", "file", js.FileName, "err", err)
+				continue
+			}
+			onChange()
+		}
+	}
+}
+
 func (js *JSONStorage) unsafeDump() error {
-	data, err := json.MarshalIndent(js.cache, "", "    ")
+	if js.FileName == "" {
+		return nil
+	}
+	var dump = make(map[string]interface{}, len(js.cache)+len(js.kept))
+	for url, value := range js.kept {
+		dump[url] = value
+	}
+	for url, rule := range js.cache {
+		value, err := encodeJSONRule(rule)
+		if err != nil {
+			return fmt.Errorf("marshal JSON config rule %v: %w", url, err)
+		}
+		dump[url] = value
+	}
+	data, err := json.MarshalIndent(dump, "", "    ")
 	if err != nil {
 		return fmt.Errorf("marshal JSON config: %w", err)
 	}
-	return ioutil.WriteFile(js.FileName, data, 0600)
+	return writeFileAtomic(js.FileName, data, 0600)
+}
+
+// locks of files written by writeFileAtomic, keyed by absolute path.
+var fileLocks sync.Map // nolint:gochecknoglobals
+
+// writeFileAtomic writes data to temporary file in the same directory, syncs it and renames to the target name,
+// so the target file is never left partially written. Concurrent writes to the same file are serialized.
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	abs, err := filepath.Abs(fileName)
+	if err != nil {
+		return fmt.Errorf("resolve path: %w", err)
+	}
+	lock, _ := fileLocks.LoadOrStore(abs, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(abs), "."+filepath.Base(abs)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // nolint:errcheck // no-op after rename
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("set permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), abs); err != nil {
+		return fmt.Errorf("replace file: %w", err)
+	}
+	return nil
+}
+
+// decode rule from plain location string or from object.
+func decodeJSONRule(value json.RawMessage) (*Rule, error) {
+	var rule Rule
+	if bytes.HasPrefix(bytes.TrimSpace(value), []byte(`"`)) {
+		return &rule, json.Unmarshal(value, &rule.LocationTemplate)
+	}
+	return &rule, json.Unmarshal(value, &rule)
+}
+
+// encode rule attributes (except URL and location) as JSON object.
+func encodeRuleAttributes(rule *Rule) (string, error) {
+	cp := *rule
+	cp.URL = ""
+	cp.LocationTemplate = ""
+	data, err := json.Marshal(&cp)
+	return string(data), err
+}
+
+// encode rule without URL (it's a key). Rules without attributes except location encoded as plain string.
+func encodeJSONRule(rule *Rule) (json.RawMessage, error) {
+	cp := *rule
+	cp.URL = ""
+	full, err := json.Marshal(&cp)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(&Rule{LocationTemplate: cp.LocationTemplate})
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(full, plain) {
+		return json.Marshal(cp.LocationTemplate)
+	}
+	return full, nil
 }
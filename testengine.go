@@ -0,0 +1,27 @@
+package redirect
+
+import (
+	"context"
+	"fmt"
+)
+
+// NewTestEngine creates engine with in-memory storage and stats for tests of rules, so redirects can be checked by
+// httptest without files. Rules are location templates by service name, options are the same as for NewEngine.
+// Panics if rules or options are invalid. See examples of usage.
+func NewTestEngine(rules map[string]string, options ...Option) Engine {
+	ctx := context.Background()
+	storage := &JSONStorage{}
+	for url, location := range rules {
+		if err := storage.Put(ctx, Rule{URL: url, LocationTemplate: location}); err != nil {
+			panic(fmt.Sprintf("redirect: test engine: %v", err))
+		}
+	}
+	eng, err := NewEngine(storage, InMemoryStats(), options...)
+	if err != nil {
+		panic(fmt.Sprintf("redirect: test engine: %v", err))
+	}
+	if err := eng.Reload(ctx); err != nil {
+		panic(fmt.Sprintf("redirect: test engine: %v", err))
+	}
+	return eng
+}
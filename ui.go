@@ -3,14 +3,30 @@ package redirect
 import (
 	"embed"
 	"encoding/json"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	formFieldTemplate = "template"
 	formFieldService  = "service"
+	formFieldDisabled = "disabled"
+	formFieldDesc     = "description"
+	formFieldTags     = "tags" // comma separated
 	headerRedirPort   = "X-Redir-Port"
+	bundlePath        = "_bundle"
+	csvPath           = "_csv"
+	seriesPath        = "_series"
+	bulkPath          = "_bulk"
+	queryImportMode   = "mode"
+	queryOverwrite    = "overwrite"
+	formFieldFile     = "file"
+	queryFrom         = "from"
+	queryTo           = "to"
+	defaultSeriesSpan = 24 * time.Hour
 )
 
 //go:embed ui/*
@@ -21,11 +37,12 @@ func DefaultUIStatic() embed.FS {
 	return defaultStaticUI
 }
 
-// description of rule for API request.
+// description of rule for API request. Template is the same as location and kept for backward compatibility.
 type UIEntry struct {
+	Rule
 	Template string `json:"template"`
 	Hits     int64  `json:"hits"`
-	URL      string `json:"url"`
+	Bots     int64  `json:"bots"` // visits of robots included in hits
 }
 
 type basicUI struct {
@@ -33,9 +50,29 @@ type basicUI struct {
 	stats     StatReader
 	engine    Engine
 	redirPort string
+	publicURL string   // base URL of redirects, optional
+	auditLog  AuditLog // sink of rule changes, optional
 }
 
-func DefaultUI(storage Storage, stats StatReader, engine Engine, redirPort string) http.Handler {
+// UIOption configures UI.
+type UIOption func(ui *basicUI)
+
+// WithPublicURL sets base URL of redirect server (ex: https://go.example.com) used for links in QR codes. By default,
+// host of UI request with redirect port is used.
+func WithPublicURL(url string) UIOption {
+	return func(ui *basicUI) {
+		ui.publicURL = url
+	}
+}
+
+// WithAuditLog records each change of rules made by UI or API (after it's applied) to the log.
+func WithAuditLog(log AuditLog) UIOption {
+	return func(ui *basicUI) {
+		ui.auditLog = log
+	}
+}
+
+func DefaultUI(storage Storage, stats StatReader, engine Engine, redirPort string, options ...UIOption) http.Handler {
 	if storage == nil {
 		panic("ui storage is nil")
 	}
@@ -45,17 +82,52 @@ func DefaultUI(storage Storage, stats StatReader, engine Engine, redirPort strin
 	if engine == nil {
 		panic("ui engine ref is nil")
 	}
-	return &basicUI{
+	ui := &basicUI{
 		stats:     stats,
 		storage:   storage,
 		engine:    engine,
 		redirPort: redirPort,
 	}
+	for _, opt := range options {
+		opt(ui)
+	}
+	return ui
 }
 
 func (ui *basicUI) ServeHTTP(wr http.ResponseWriter, rq *http.Request) {
 	defer rq.Body.Close()
 	service := strings.Trim(rq.URL.Path, "/")
+	if service == rulesPath || strings.HasPrefix(service, rulesPath+"/") {
+		ui.rules(wr, rq)
+		return
+	}
+	if service == statsPath || strings.HasPrefix(service, statsPath+"/") {
+		ui.resetStats(wr, rq)
+		return
+	}
+	switch service {
+	case bundlePath:
+		ui.bundle(wr, rq)
+		return
+	case csvPath:
+		ui.importCSV(wr, rq)
+		return
+	case seriesPath:
+		ui.series(wr, rq)
+		return
+	case bulkPath:
+		ui.bulk(wr, rq)
+		return
+	case resolvePath:
+		ui.resolve(wr, rq)
+		return
+	case configPath:
+		ui.config(wr, rq)
+		return
+	case statusPath:
+		ui.status(wr, rq)
+		return
+	}
 	switch rq.Method {
 	case http.MethodGet:
 		if service == "" {
@@ -72,18 +144,19 @@ func (ui *basicUI) ServeHTTP(wr http.ResponseWriter, rq *http.Request) {
 	}
 }
 
-func (ui *basicUI) list(wr http.ResponseWriter, _ *http.Request) {
+// list of rules with hits keyed by URL. Supports the same filter as REST API (q, sort, offset, limit).
+func (ui *basicUI) list(wr http.ResponseWriter, rq *http.Request) {
 	var ans = make(map[string]*UIEntry)
-	entries, err := ui.storage.All()
-	if err != nil {
-		http.Error(wr, err.Error(), http.StatusInternalServerError)
+	entries, ok := ui.queryRules(wr, rq)
+	if !ok {
 		return
 	}
 	for _, elem := range entries {
 		ans[elem.URL] = &UIEntry{
-			URL:      elem.URL,
+			Rule:     elem.Rule,
 			Template: elem.LocationTemplate,
-			Hits:     ui.stats.Visits(elem.URL),
+			Hits:     elem.Hits,
+			Bots:     elem.Bots,
 		}
 	}
 	wr.Header().Set(headerRedirPort, ui.redirPort)
@@ -91,65 +164,237 @@ func (ui *basicUI) list(wr http.ResponseWriter, _ *http.Request) {
 }
 
 func (ui *basicUI) get(service string, wr http.ResponseWriter, rq *http.Request) {
-	template, exists := ui.storage.Get(service)
+	rule, exists, err := ui.storage.Get(rq.Context(), service)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	if !exists {
 		http.NotFound(wr, rq)
 		return
 	}
+	hits, bots, err := ui.counts(service)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	wr.Header().Set(headerRedirPort, ui.redirPort)
 	sendJSON(&UIEntry{
-		URL:      service,
-		Hits:     ui.stats.Visits(service),
-		Template: template,
+		Rule:     rule,
+		Hits:     hits,
+		Bots:     bots,
+		Template: rule.LocationTemplate,
 	}, wr)
 }
 
-func (ui *basicUI) remove(service string, wr http.ResponseWriter, _ *http.Request) {
-	err := ui.storage.Remove(service)
+// counts of all and robots visits of service. Robots are zero if stats do not count them.
+func (ui *basicUI) counts(service string) (hits, bots int64, err error) {
+	hits, err = ui.stats.Count(service)
+	if err != nil {
+		return 0, 0, err
+	}
+	if reader, ok := ui.stats.(StatBots); ok {
+		bots, err = reader.Bots(service)
+	}
+	return hits, bots, err
+}
+
+// split comma separated tags, empty tags are skipped.
+func splitTags(text string) []string {
+	var tags []string
+	for _, tag := range strings.Split(text, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (ui *basicUI) remove(service string, wr http.ResponseWriter, rq *http.Request) {
+	rb, err := snapshotRules(rq.Context(), ui.storage, service)
 	if err != nil {
 		http.Error(wr, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = ui.engine.Reload()
+	err = ui.apply(rq, rb, func() error {
+		return ui.storage.Delete(rq.Context(), service)
+	})
 	if err != nil {
-		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		sendMutationError(wr, err)
 		return
 	}
 	wr.WriteHeader(http.StatusNoContent)
 }
 
 func (ui *basicUI) set(wr http.ResponseWriter, rq *http.Request) {
-	var entry UIEntry
+	var rule Rule
 	if strings.Contains(rq.Header.Get("Content-Type"), "application/json") {
 		// parse entry as-is except hits
+		var entry UIEntry
 		err := json.NewDecoder(rq.Body).Decode(&entry)
 		if err != nil {
 			http.Error(wr, err.Error(), http.StatusBadRequest)
 			return
 		}
+		rule = entry.Rule
+		if entry.Template != "" {
+			rule.LocationTemplate = entry.Template
+		}
 	} else {
-		// use form
+		// use form: update template (and optionally disabled flag, description and tags) of existing rule
 		err := rq.ParseForm()
 		if err != nil {
 			http.Error(wr, err.Error(), http.StatusBadRequest)
 			return
 		}
-		entry.URL = rq.FormValue(formFieldService)
-		entry.Template = rq.FormValue(formFieldTemplate)
+		service := rq.FormValue(formFieldService)
+		rule, _, err = ui.storage.Get(rq.Context(), service)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rule.URL = service
+		rule.LocationTemplate = rq.FormValue(formFieldTemplate)
+		if value := rq.FormValue(formFieldDisabled); value != "" {
+			rule.Disabled, err = strconv.ParseBool(value)
+			if err != nil {
+				http.Error(wr, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if rq.Form.Has(formFieldDesc) {
+			rule.Description = rq.FormValue(formFieldDesc)
+		}
+		if rq.Form.Has(formFieldTags) {
+			rule.Tags = splitTags(rq.FormValue(formFieldTags))
+		}
 	}
-	err := ui.storage.Set(entry.URL, entry.Template)
+	rb, err := snapshotRules(rq.Context(), ui.storage, rule.URL)
 	if err != nil {
 		http.Error(wr, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = ui.engine.Reload()
+	err = ui.apply(rq, rb, func() error {
+		return ui.storage.Put(rq.Context(), rule)
+	})
 	if err != nil {
-		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		sendMutationError(wr, err)
 		return
 	}
 	wr.WriteHeader(http.StatusNoContent)
 }
 
+// export (GET) or import (POST, PUT) rules bundle.
+func (ui *basicUI) bundle(wr http.ResponseWriter, rq *http.Request) {
+	switch rq.Method {
+	case http.MethodGet:
+		wr.Header().Set("Content-Type", "application/json; charset=utf-8")
+		wr.Header().Set("Content-Disposition", `attachment; filename="redirect.json"`)
+		if err := Export(rq.Context(), ui.storage, wr); err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+		}
+	case http.MethodPost, http.MethodPut:
+		mode := ImportMerge
+		if rq.URL.Query().Get(queryImportMode) == "replace" {
+			mode = ImportReplace
+		}
+		rb, err := snapshotAll(rq.Context(), ui.storage)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = ui.apply(rq, rb, func() error {
+			return Import(rq.Context(), ui.storage, rq.Body, mode)
+		})
+		if err != nil {
+			sendMutationError(wr, err)
+			return
+		}
+		wr.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(wr, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// import rules from uploaded CSV (multipart form field file or raw body) and send report.
+func (ui *basicUI) importCSV(wr http.ResponseWriter, rq *http.Request) {
+	if rq.Method != http.MethodPost && rq.Method != http.MethodPut {
+		http.Error(wr, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var input io.Reader = rq.Body
+	if strings.HasPrefix(rq.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := rq.FormFile(formFieldFile)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		input = file
+	}
+	overwrite, _ := strconv.ParseBool(rq.URL.Query().Get(queryOverwrite))
+	rb, err := snapshotAll(rq.Context(), ui.storage)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var report *CSVReport
+	var importErr error // failed in the middle, imported rules are kept
+	err = ui.apply(rq, rb, func() error {
+		report, importErr = ImportCSV(rq.Context(), ui.storage, input, overwrite)
+		if report == nil {
+			return &ValidationError{Err: importErr} // nothing imported
+		}
+		return nil
+	})
+	if err != nil {
+		sendMutationError(wr, err)
+		return
+	}
+	if importErr != nil {
+		http.Error(wr, importErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendJSON(report, wr)
+}
+
+// send visits of service (query param service) per time bucket between from and to (RFC3339, last 24 hours by default).
+func (ui *basicUI) series(wr http.ResponseWriter, rq *http.Request) {
+	reader, ok := ui.stats.(StatSeries)
+	if !ok {
+		http.Error(wr, "time series not supported by stats", http.StatusNotImplemented)
+		return
+	}
+	query := rq.URL.Query()
+	to := time.Now()
+	if value := query.Get(queryTo); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultSeriesSpan)
+	if value := query.Get(queryFrom); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	points, err := reader.Series(query.Get(formFieldService), from, to)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if points == nil {
+		points = []SeriesPoint{}
+	}
+	sendJSON(points, wr)
+}
+
 // correctly send JSON with required headers.
 func sendJSON(data interface{}, w http.ResponseWriter) {
 	content, err := json.MarshalIndent(data, "", "    ")
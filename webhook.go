@@ -0,0 +1,132 @@
+package redirect
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of webhook delivery.
+const (
+	DefaultWebhookQueue   = 1024
+	DefaultWebhookRetries = 3
+	webhookTimeout        = 5 * time.Second
+	webhookBackoff        = time.Second // delay before first retry, doubled after each attempt
+)
+
+// WebhookEvent is JSON body sent to webhook after each successful redirect.
+type WebhookEvent struct {
+	Service   string    `json:"service"`
+	Target    string    `json:"target"`
+	UserAgent string    `json:"userAgent"`
+	Timestamp time.Time `json:"ts"`
+	IP        string    `json:"ip"`
+	url       string    // destination of event
+}
+
+// asynchronous webhook sender. Events are delivered one by one from bounded queue by single worker (started on
+// first event), events are dropped if queue is full.
+type webhook struct {
+	client  *http.Client
+	queue   chan *WebhookEvent
+	retries int
+	logger  *slog.Logger
+	start   sync.Once
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newWebhook(queue, retries int, logger *slog.Logger) *webhook {
+	return &webhook{
+		client:  &http.Client{Timeout: webhookTimeout},
+		queue:   make(chan *WebhookEvent, queue),
+		retries: retries,
+		logger:  logger,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// enqueue event without blocking.
+func (wh *webhook) notify(event *WebhookEvent) {
+	wh.start.Do(func() {
+		wh.started = true
+		go wh.run()
+	})
+	select {
+	case <-wh.stop:
+	case wh.queue <- event:
+	default:
+		wh.logger.Warn("webhook queue is full, event dropped", "service", event.Service, "webhook", event.url)
+	}
+}
+
+// close stops worker. Queued events are delivered without retries.
+func (wh *webhook) close() {
+	wh.start.Do(func() {}) // prevent start after close
+	close(wh.stop)
+	if wh.started {
+		<-wh.done
+	}
+}
+
+func (wh *webhook) run() {
+	defer close(wh.done)
+	for {
+		select {
+		case <-wh.stop:
+			for {
+				select {
+				case event := <-wh.queue:
+					wh.deliver(event, 0)
+				default:
+					return
+				}
+			}
+		case event := <-wh.queue:
+			wh.deliver(event, wh.retries)
+		}
+	}
+}
+
+// send event with exponential backoff between attempts. Waiting is interrupted by close.
+func (wh *webhook) deliver(event *WebhookEvent, retries int) {
+	backoff := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		err := wh.send(event)
+		if err == nil {
+			return
+		}
+		if attempt >= retries {
+			wh.logger.Error("failed deliver webhook", "service", event.Service, "webhook", event.url, "attempts", attempt+1, "err", err)
+			return
+		}
+		select {
+		case <-wh.stop:
+			retries = 0 // last attempt on close
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func (wh *webhook) send(event *WebhookEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	res, err := wh.client.Post(event.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}